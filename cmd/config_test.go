@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"testing"
+)
+
+func TestApplyConfigEnvConflicts(t *testing.T) {
+	cmd := &cobra.Command{Use: "test", Run: func(*cobra.Command, []string) {}}
+	addDatabaseFlags(cmd)
+	// --db and --sqliteFile are mutually exclusive: only the first variable, in flag order, applies.
+	t.Setenv(envName("db"), "from-env.db")
+	t.Setenv(envName("sqliteFile"), "legacy.db")
+
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := cmd.Flags().GetString("db"); db != "from-env.db" {
+		t.Errorf("--db = %q, want from-env.db", db)
+	}
+	if cmd.Flags().Changed("sqliteFile") {
+		t.Errorf("--sqliteFile was set from the environment despite --db")
+	}
+	if err := cmd.ValidateFlagGroups(); err != nil {
+		t.Errorf("flag groups: %v", err)
+	}
+}
+
+func TestApplyConfigEnvAfterCommandLine(t *testing.T) {
+	cmd := &cobra.Command{Use: "test", Run: func(*cobra.Command, []string) {}}
+	addDatabaseFlags(cmd)
+	if err := cmd.Flags().Set("sqliteFile", "flag.db"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envName("db"), "from-env.db")
+
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Flags().Changed("db") {
+		t.Errorf("--db was set from the environment despite --sqliteFile on the command line")
+	}
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/gcp"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"golang.org/x/sync/errgroup"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dumper runs the collectors of a dump, sharing API clients and the database between them.
+type dumper struct {
+	clients  *gcp.Clients
+	database db.Storage
+}
+
+// dumpParameters are the flags deciding what a dump collects. They are recorded with the run, and must be the same
+// when resuming it.
+type dumpParameters struct {
+	// Tenants are the organizations and Workspace customers collected, sorted by ID.
+	Tenants []tenant `json:"tenants,omitempty"`
+	// GCPOrgID, Scopes and WorkspaceOrgID are the single tenant recorded by runs started before tenants existed.
+	GCPOrgID       string   `json:"gcpOrgId,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	WorkspaceOrgID string   `json:"workspaceOrgId,omitempty"`
+	// UnchangedSince is set on runs keeping the bindings of the previous one, which only rewrite the bindings of
+	// resources whose IAM policy changed since then.
+	UnchangedSince *time.Time `json:"unchangedSince,omitempty"`
+	// ReadTime is set on point-in-time runs, which read Asset API data as of then.
+	ReadTime *time.Time `json:"readTime,omitempty"`
+	// Collectors are the collectors run, all of them if empty.
+	Collectors []string `json:"collectors,omitempty"`
+	// Source is set on runs ingesting a Cloud Asset Inventory export instead of calling the APIs, and SourceTenant is
+	// the tenant they tag the ingested rows with.
+	Source       string `json:"source,omitempty"`
+	SourceTenant string `json:"sourceTenant,omitempty"`
+}
+
+// collects tells whether a run runs the collector name.
+func (p dumpParameters) collects(name string) bool {
+	return len(p.Collectors) == 0 || slices.Contains(p.Collectors, name)
+}
+
+// tenants returns the tenants of a run, including the single tenant of runs recorded before tenants existed.
+func (p dumpParameters) tenants() []tenant {
+	if len(p.Tenants) > 0 || (p.GCPOrgID == "" && len(p.Scopes) == 0 && p.WorkspaceOrgID == "") {
+		return p.Tenants
+	}
+	scopes := slices.Clone(p.Scopes)
+	if len(scopes) == 0 && p.GCPOrgID != "" {
+		scopes = []string{gcp.OrganizationScope(p.GCPOrgID)}
+	}
+	return []tenant{newTenant("", scopes, p.WorkspaceOrgID)}
+}
+
+// sameSources tells whether two runs collect the same tenants.
+func (p dumpParameters) sameSources(other dumpParameters) bool {
+	return slices.EqualFunc(p.tenants(), other.tenants(), tenant.equal)
+}
+
+// collectorNames are the collectors of a dump, as selected by --collectors. They prefix the keys of their steps.
+var collectorNames = []string{"roles", "permissions", "hierarchy", "groups", "service_accounts", "resources", "tags", "bindings"}
+
+// dumpCollectors returns the collectors selected by --collectors, sorted, or nil for all of them.
+func dumpCollectors(cmd *cobra.Command) []string {
+	collectors, _ := cmd.Flags().GetStringSlice("collectors")
+	for _, name := range collectors {
+		if !slices.Contains(collectorNames, name) {
+			log.Fatalf("Unknown collector %q in --collectors, expected %s", name, strings.Join(collectorNames, ", "))
+		}
+	}
+	if len(collectors) == 0 {
+		return nil
+	}
+	slices.Sort(collectors)
+	return slices.Compact(collectors)
+}
+
+// updateTimeClockSkew is subtracted from the start of the run whose unchanged bindings a dump keeps, so that policy
+// update times, set by Google's clocks, compare safely with run start times, set by the local clock.
+const updateTimeClockSkew = 5 * time.Minute
+
+// step is one collector of a dump. Paged collectors start from pageToken, the token saved by their last checkpoint
+// (the first page if empty), and call checkpoint once a page is written; the step is complete once the last page is.
+type step struct {
+	key  string
+	name string
+	run  func(ctx context.Context, pageToken string, checkpoint func(ctx context.Context, nextPageToken string) error) error
+}
+
+// begin starts a new run, clearing the data of the previous one, or resumes the run resumeID, which must be the last
+// run started on the database. With keepUnchanged, a new run keeps the bindings of the previous run if it completed,
+// and falls back to a full dump otherwise; an ingestion only clears the data of its tenant. begin returns the ID of the
+// run along with its parameters.
+func (d *dumper) begin(ctx context.Context, resumeID string, params dumpParameters, keepUnchanged bool) (string, dumpParameters, error) {
+	if resumeID != "" {
+		return d.resume(ctx, resumeID, params)
+	}
+
+	var keep []string
+	if keepUnchanged {
+		since, reason, err := d.unchangedSince(ctx, params)
+		if err != nil {
+			return "", params, err
+		}
+		if reason != "" {
+			fmt.Printf("Running a full dump: %s\n", reason)
+		} else {
+			fmt.Printf("Keeping the bindings of the IAM policies unchanged since %s\n", since.Format(time.RFC3339))
+			params.UnchangedSince = &since
+			keep = append(keep, db.BindingsTable)
+		}
+	}
+
+	var err error
+	if params.Source != "" {
+		// An ingestion only replaces the rows of its tenant, leaving those of the other tenants of the database.
+		err = d.database.ClearTenantData(ctx, params.SourceTenant)
+	} else {
+		err = d.database.ClearData(ctx, keep...)
+	}
+	if err != nil {
+		return "", params, fmt.Errorf("failed to clear previous dump: %v", err)
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "", params, err
+	}
+	run := db.Run{ID: uuid.NewString(), Parameters: string(encoded), Status: db.RunRunning, StartedAt: time.Now()}
+	if err := d.database.StartRun(ctx, run); err != nil {
+		return "", params, fmt.Errorf("failed to record run: %v", err)
+	}
+	return run.ID, params, nil
+}
+
+// resume checks that the run resumeID can be resumed with params and returns its recorded parameters.
+func (d *dumper) resume(ctx context.Context, resumeID string, params dumpParameters) (string, dumpParameters, error) {
+	run, err := d.database.GetRun(ctx, resumeID)
+	if err != nil {
+		return "", params, fmt.Errorf("looking up run %s: %v", resumeID, err)
+	}
+	if run.Status == db.RunCompleted {
+		return "", params, fmt.Errorf("run %s already completed", run.ID)
+	}
+	latest, err := d.database.LatestRun(ctx)
+	if err != nil {
+		return "", params, err
+	}
+	if latest.ID != run.ID {
+		return "", params, fmt.Errorf("run %s can't be resumed: the database holds the data of run %s started since", run.ID, latest.ID)
+	}
+	var recorded dumpParameters
+	if err := json.Unmarshal([]byte(run.Parameters), &recorded); err != nil {
+		return "", params, fmt.Errorf("decoding parameters of run %s: %v", run.ID, err)
+	}
+	if !recorded.sameSources(params) || !slices.Equal(recorded.Collectors, params.Collectors) || !sameTime(recorded.ReadTime, params.ReadTime) {
+		return "", params, fmt.Errorf("run %s was started with different parameters: %s", run.ID, run.Parameters)
+	}
+	if err := d.database.SetRunStatus(ctx, run.ID, db.RunRunning); err != nil {
+		return "", params, err
+	}
+	return run.ID, recorded, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// unchangedSince returns the time since which the IAM policies whose bindings a dump keeps must be unchanged: the
+// start, or read time, of the last run, if it completed and collected the same tenants. Otherwise, it returns why a full
+// dump is needed.
+func (d *dumper) unchangedSince(ctx context.Context, params dumpParameters) (time.Time, string, error) {
+	latest, err := d.database.LatestRun(ctx)
+	if errors.Is(err, db.ErrRunNotFound) {
+		return time.Time{}, "no previous run to build on", nil
+	}
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if latest.Status != db.RunCompleted {
+		return time.Time{}, fmt.Sprintf("the last run %s did not complete", latest.ID), nil
+	}
+	var previous dumpParameters
+	if err := json.Unmarshal([]byte(latest.Parameters), &previous); err != nil {
+		return time.Time{}, "", fmt.Errorf("decoding parameters of run %s: %v", latest.ID, err)
+	}
+	if previous.Source != "" {
+		return time.Time{}, fmt.Sprintf("the last run %s ingested an asset export", latest.ID), nil
+	}
+	if !previous.collects("bindings") {
+		return time.Time{}, fmt.Sprintf("the last run %s did not collect bindings", latest.ID), nil
+	}
+	if !previous.sameSources(params) {
+		return time.Time{}, fmt.Sprintf("the last run %s collected other tenants", latest.ID), nil
+	}
+	// A point-in-time run holds the state of assets at its read time rather than at its start.
+	since := latest.StartedAt
+	if previous.ReadTime != nil {
+		since = *previous.ReadTime
+	}
+	return since.Add(-updateTimeClockSkew), "", nil
+}
+
+// run executes the collectors of a run that haven't completed yet, up to parallelism at a time. The first failing
+// collector cancels the others. Collectors run, and checkpoint, once per tenant, and paged ones once per scope of the
+// tenant; the Workspace collector only runs for tenants with a Workspace organization.
+func (d *dumper) run(ctx context.Context, runID string, params dumpParameters, parallelism int) error {
+	tenants := params.tenants()
+	// stepName tells apart the steps of each tenant, if there are several, and of each scope.
+	stepName := func(name string, t tenant, scope string) string {
+		if scope != "" {
+			name += " of " + scope
+		}
+		if len(tenants) > 1 {
+			name += " (tenant " + t.ID + ")"
+		}
+		return name
+	}
+
+	steps := []step{
+		{"roles", "Roles", func(ctx context.Context, _ string, _ func(context.Context, string) error) error {
+			return d.syncRoles(ctx, tenants)
+		}},
+	}
+	for _, t := range tenants {
+		t := t
+		steps = append(steps, step{"hierarchy:" + t.ID, stepName("Hierarchy", t, ""), func(ctx context.Context, _ string, _ func(context.Context, string) error) error {
+			return d.syncHierarchy(ctx, t)
+		}})
+		if t.WorkspaceOrgID != "" {
+			steps = append(steps, step{"groups:" + t.ID, stepName("GroupAndMembers", t, ""), func(ctx context.Context, _ string, _ func(context.Context, string) error) error {
+				return d.syncGroupAndMembers(ctx, t)
+			}})
+		} else {
+			fmt.Printf("Skipping %s, no Workspace organization given\n", stepName("GroupAndMembers", t, ""))
+		}
+		steps = append(steps, step{"permissions:" + t.ID, stepName("Permissions", t, ""), func(ctx context.Context, _ string, _ func(context.Context, string) error) error {
+			return d.syncPermissions(ctx, t)
+		}})
+		steps = append(steps, step{"tags:" + t.ID, stepName("Tags", t, ""), func(ctx context.Context, _ string, _ func(context.Context, string) error) error {
+			return d.syncTags(ctx, t)
+		}})
+		for _, scope := range t.Scopes {
+			scope := scope
+			steps = append(steps, step{"service_accounts:" + t.ID + ":" + scope, stepName("Service Accounts", t, scope), func(ctx context.Context, pageToken string, checkpoint func(context.Context, string) error) error {
+				return d.syncServiceAccounts(ctx, t.ID, scope, pageToken, checkpoint)
+			}})
+			steps = append(steps, step{"resources:" + t.ID + ":" + scope, stepName("Resources", t, scope), func(ctx context.Context, pageToken string, checkpoint func(context.Context, string) error) error {
+				return d.syncResources(ctx, t.ID, scope, pageToken, checkpoint)
+			}})
+			if params.UnchangedSince == nil {
+				steps = append(steps, step{"bindings:" + t.ID + ":" + scope, stepName("Bindings", t, scope), func(ctx context.Context, pageToken string, checkpoint func(context.Context, string) error) error {
+					return d.syncBindings(ctx, t.ID, scope, pageToken, checkpoint)
+				}})
+			}
+		}
+	}
+	if params.UnchangedSince != nil {
+		// Resources no longer listed in any scope are deleted, so the scopes are listed by a single step.
+		steps = append(steps, step{"bindings", "Bindings", func(ctx context.Context, _ string, _ func(context.Context, string) error) error {
+			return d.syncChangedBindings(ctx, tenants, *params.UnchangedSince)
+		}})
+	}
+
+	checkpoints, err := d.database.Checkpoints(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoints: %v", err)
+	}
+	progress := map[string]db.Checkpoint{}
+	for _, c := range checkpoints {
+		progress[c.Step] = c
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(parallelism, 1))
+	for _, s := range steps {
+		s := s
+		if collector, _, _ := strings.Cut(s.key, ":"); !params.collects(collector) {
+			continue
+		}
+		if progress[s.key].Completed {
+			fmt.Printf("Skipping %s, completed by a previous attempt\n", s.name)
+			continue
+		}
+		g.Go(func() error {
+			pageToken := progress[s.key].PageToken
+			if pageToken != "" {
+				fmt.Printf("Resuming %s from its last checkpoint...\n", s.name)
+			} else {
+				fmt.Printf("Syncing %s...\n", s.name)
+			}
+			checkpoint := func(ctx context.Context, nextPageToken string) error {
+				return d.database.SaveCheckpoint(ctx, db.Checkpoint{RunID: runID, Step: s.key, PageToken: nextPageToken, Completed: nextPageToken == ""})
+			}
+			if err := s.run(ctx, pageToken, checkpoint); err != nil {
+				return fmt.Errorf("syncing %s: %v", s.name, err)
+			}
+			if err := d.database.SaveCheckpoint(ctx, db.Checkpoint{RunID: runID, Step: s.key, Completed: true}); err != nil {
+				return fmt.Errorf("saving checkpoint of %s: %v", s.name, err)
+			}
+			fmt.Printf("Synced %s\n", s.name)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if params.collects("roles") && params.collects("bindings") && params.ReadTime == nil {
+		if err := d.syncReferencedRoles(ctx); err != nil {
+			return fmt.Errorf("syncing referenced roles: %v", err)
+		}
+	}
+	// Bindings are attributed once the whole hierarchy is known, whichever step collected it.
+	if err := d.database.ResolveHierarchy(ctx); err != nil {
+		return fmt.Errorf("resolving hierarchy: %v", err)
+	}
+	// Tags attached to resources are only known from searched resources, which point-in-time dumps don't search.
+	if params.collects("resources") && params.collects("bindings") && params.ReadTime == nil {
+		if err := d.database.AssessConditions(ctx, gcp.EvaluateTagCondition); err != nil {
+			return fmt.Errorf("assessing conditions: %v", err)
+		}
+	}
+	return nil
+}
+
+// syncRoles collects the predefined roles, shared by every tenant, and the custom roles of each tenant.
+func (d *dumper) syncRoles(ctx context.Context, tenants []tenant) error {
+	roles, err := d.clients.FetchPredefinedRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch roles: %v", err)
+	}
+	for _, t := range tenants {
+		customRoles, err := d.clients.FetchCustomRoles(ctx, t.Scopes)
+		if err != nil {
+			return fmt.Errorf("failed to fetch roles: %v", err)
+		}
+		for _, role := range customRoles {
+			role.TenantID = t.ID
+			roles = append(roles, role)
+		}
+	}
+	if err := d.database.InsertRoles(ctx, roles); err != nil {
+		return fmt.Errorf("failed to insert roles: %v", err)
+	}
+	return nil
+}
+
+func (d *dumper) syncBindings(ctx context.Context, tenantID, scope, pageToken string, checkpoint func(context.Context, string) error) error {
+	fetch := func(ctx context.Context, out chan<- model.Page[gcp.IAMPolicy]) error {
+		return d.clients.FetchAssetIAMPolicy(ctx, scope, pageToken, out)
+	}
+	insert := func(ctx context.Context, policies []gcp.IAMPolicy) error {
+		var bindings []model.ResourceIAMPermission
+		var ancestors []model.ResourceAncestor
+		for _, policy := range policies {
+			for _, binding := range policy.Bindings {
+				binding.TenantID = tenantID
+				bindings = append(bindings, binding)
+			}
+			ancestors = append(ancestors, policy.AncestorRecords(tenantID)...)
+		}
+		if err := d.database.InsertResourceIAMPermission(ctx, bindings); err != nil {
+			return err
+		}
+		return d.database.InsertResourceAncestors(ctx, ancestors)
+	}
+	return db.StreamPages(ctx, fetch, insert, checkpoint)
+}
+
+// syncChangedBindings rewrites the bindings of the resources whose IAM policy changed since the given time, and
+// deletes those of the resources that no longer have one in any scope of any tenant. Every policy is listed, the Asset
+// API offering no way to list only those changed since a time, and the ancestors of every resource are stored again,
+// as in a full dump. It doesn't checkpoint: a resumed run starts over, rewriting the changed resources again.
+func (d *dumper) syncChangedBindings(ctx context.Context, tenants []tenant, since time.Time) error {
+	listed := map[string]struct{}{}
+	changed := 0
+	noCheckpoint := func(context.Context, string) error { return nil }
+	for _, t := range tenants {
+		apply := func(ctx context.Context, assets []gcp.IAMPolicyAsset) error {
+			var resourceIDs []string
+			var bindings []model.ResourceIAMPermission
+			var ancestors []model.ResourceAncestor
+			for _, asset := range assets {
+				listed[asset.ResourceID] = struct{}{}
+				ancestors = append(ancestors, asset.AncestorRecords(t.ID)...)
+				if asset.UpdateTime.After(since) {
+					resourceIDs = append(resourceIDs, asset.ResourceID)
+					for _, binding := range asset.Bindings {
+						binding.TenantID = t.ID
+						bindings = append(bindings, binding)
+					}
+				}
+			}
+			changed += len(resourceIDs)
+			if err := d.database.DeleteResourceIAMPermissions(ctx, resourceIDs); err != nil {
+				return err
+			}
+			if err := d.database.InsertResourceIAMPermission(ctx, bindings); err != nil {
+				return err
+			}
+			return d.database.InsertResourceAncestors(ctx, ancestors)
+		}
+		for _, scope := range t.Scopes {
+			fetch := func(ctx context.Context, out chan<- model.Page[gcp.IAMPolicyAsset]) error {
+				return d.clients.FetchIAMPolicyAssets(ctx, scope, out)
+			}
+			if err := db.StreamPages(ctx, fetch, apply, noCheckpoint); err != nil {
+				return fmt.Errorf("%s: %v", scope, err)
+			}
+		}
+	}
+
+	stored, err := d.database.ListResourceIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list stored resources: %v", err)
+	}
+	var removed []string
+	for _, resourceID := range stored {
+		if _, ok := listed[resourceID]; !ok {
+			removed = append(removed, resourceID)
+		}
+	}
+	if err := d.database.DeleteResourceIAMPermissions(ctx, removed); err != nil {
+		return err
+	}
+	fmt.Printf("Bindings: %d resources changed, %d removed\n", changed, len(removed))
+	return nil
+}
+
+func (d *dumper) syncServiceAccounts(ctx context.Context, tenantID, scope, pageToken string, checkpoint func(context.Context, string) error) error {
+	fetch := func(ctx context.Context, out chan<- model.Page[model.Principal]) error {
+		return d.clients.FetchServiceAccounts(ctx, scope, pageToken, out)
+	}
+	insert := func(ctx context.Context, principals []model.Principal) error {
+		for i := range principals {
+			principals[i].TenantID = tenantID
+		}
+		return d.database.InsertPrincipals(ctx, principals)
+	}
+	return db.StreamPages(ctx, fetch, insert, checkpoint)
+}
+
+func (d *dumper) syncResources(ctx context.Context, tenantID, scope, pageToken string, checkpoint func(context.Context, string) error) error {
+	fetch := func(ctx context.Context, out chan<- model.Page[model.Resource]) error {
+		return d.clients.FetchResources(ctx, scope, pageToken, out)
+	}
+	insert := func(ctx context.Context, resources []model.Resource) error {
+		for i := range resources {
+			resources[i].TenantID = tenantID
+		}
+		return d.database.InsertResources(ctx, resources)
+	}
+	return db.StreamPages(ctx, fetch, insert, checkpoint)
+}
+
+func (d *dumper) syncGroupAndMembers(ctx context.Context, t tenant) error {
+	users, err := d.clients.FetchUsers(ctx, t.WorkspaceOrgID)
+	if err != nil {
+		return fmt.Errorf("error listing users: %v", err)
+	}
+	for i := range users {
+		users[i].TenantID = t.ID
+	}
+	if err := d.database.InsertPrincipals(ctx, users); err != nil {
+		return fmt.Errorf("failed to insert users: %v", err)
+	}
+
+	groups, err := d.clients.FetchGroups(ctx, t.WorkspaceOrgID)
+	if err != nil {
+		return fmt.Errorf("error listing groups: %v", err)
+	}
+	for i := range groups {
+		groups[i].TenantID = t.ID
+	}
+	if err := d.database.InsertPrincipals(ctx, groups); err != nil {
+		return fmt.Errorf("failed to insert groups: %v", err)
+	}
+
+	principalRelationships, principals, err := d.clients.FetchGroupsMembership(ctx, groups)
+	if err != nil {
+		return fmt.Errorf("error listing group memberships: %v", err)
+	}
+	for i := range principalRelationships {
+		principalRelationships[i].TenantID = t.ID
+	}
+	for i := range principals {
+		principals[i].TenantID = t.ID
+	}
+	if err := d.database.InsertPrincipalRelationships(ctx, principalRelationships); err != nil {
+		return fmt.Errorf("failed to insert principalRelationships: %v", err)
+	}
+	// In case there are external users, we need to track them too
+	if err := d.database.InsertPrincipals(ctx, principals); err != nil {
+		return fmt.Errorf("failed to insert principals: %v", err)
+	}
+	return nil
+}
+
+// syncReferencedRoles collects the custom roles that bindings grant but that were not found in the scopes, such as
+// roles of projects outside of them, by listing the roles of the organizations and projects defining them.
+func (d *dumper) syncReferencedRoles(ctx context.Context) error {
+	unresolved, err := d.database.UnresolvedRoles(ctx)
+	if err != nil {
+		return err
+	}
+	roleIDs := sortedKeys(unresolved)
+	roles, err := d.clients.FetchReferencedRoles(ctx, roleIDs)
+	if err != nil {
+		return err
+	}
+	// Every role of a parent, granted or not, belongs to the tenant of the first granted one.
+	tenants := map[string]string{}
+	for _, id := range roleIDs {
+		parent, _, _ := strings.Cut(id, "/roles/")
+		if _, ok := tenants[parent]; !ok {
+			tenants[parent] = unresolved[id]
+		}
+	}
+	for i := range roles {
+		roles[i].TenantID = tenants[roles[i].Parent]
+	}
+	return d.database.InsertRoles(ctx, roles)
+}
+
+// tenantParents returns the organizations of a tenant and its project scopes, which own tag keys and custom roles.
+func (d *dumper) tenantParents(ctx context.Context, t tenant) ([]string, error) {
+	ancestors, err := d.clients.FetchAncestors(ctx, t.Scopes)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving the ancestors of the scopes: %v", err)
+	}
+	var parents []string
+	for _, ancestor := range ancestors {
+		if ancestor.Type == "organization" {
+			parents = append(parents, ancestor.ID)
+		}
+	}
+	for _, scope := range t.Scopes {
+		if strings.HasPrefix(scope, "projects/") {
+			parents = append(parents, scope)
+		}
+	}
+	return parents, nil
+}
+
+// syncPermissions collects the permissions testable on the organizations of the tenant and its project scopes. They
+// are shared by every tenant, like predefined roles.
+func (d *dumper) syncPermissions(ctx context.Context, t tenant) error {
+	parents, err := d.tenantParents(ctx, t)
+	if err != nil {
+		return err
+	}
+	permissions, err := d.clients.FetchPermissions(ctx, parents)
+	if err != nil {
+		return fmt.Errorf("failed to fetch permissions: %v", err)
+	}
+	if err := d.database.InsertPermissions(ctx, permissions); err != nil {
+		return fmt.Errorf("failed to insert permissions: %v", err)
+	}
+	return nil
+}
+
+// syncTags collects the tag keys of the organizations of the tenant and of its project scopes, and their values. Tags
+// attached to resources are collected along with the resources.
+func (d *dumper) syncTags(ctx context.Context, t tenant) error {
+	parents, err := d.tenantParents(ctx, t)
+	if err != nil {
+		return err
+	}
+	keys, values, err := d.clients.FetchTags(ctx, parents)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tags: %v", err)
+	}
+	for i := range keys {
+		keys[i].TenantID = t.ID
+	}
+	for i := range values {
+		values[i].TenantID = t.ID
+	}
+	if err := d.database.InsertTags(ctx, keys, values); err != nil {
+		return fmt.Errorf("failed to insert tags: %v", err)
+	}
+	return nil
+}
+
+// syncHierarchy collects the folders and projects in every scope, then the scopes themselves and their ancestors,
+// which the Asset API doesn't return when scoped to a folder or project.
+func (d *dumper) syncHierarchy(ctx context.Context, t tenant) error {
+	for _, scope := range t.Scopes {
+		hierarchies, err := d.clients.FetchHierarchies(ctx, scope)
+		if err != nil {
+			return fmt.Errorf("error listing GCP hierarchies of %s: %v", scope, err)
+		}
+		for i := range hierarchies {
+			hierarchies[i].TenantID = t.ID
+		}
+		if err := d.database.InsertHierarchies(ctx, hierarchies); err != nil {
+			return fmt.Errorf("failed to insert hierarchies: %v", err)
+		}
+	}
+	ancestors, err := d.clients.FetchAncestors(ctx, t.Scopes)
+	if err != nil {
+		return fmt.Errorf("error resolving the ancestors of the scopes: %v", err)
+	}
+	for i := range ancestors {
+		ancestors[i].TenantID = t.ID
+	}
+	if err := d.database.InsertHierarchies(ctx, ancestors); err != nil {
+		return fmt.Errorf("failed to insert hierarchies: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/gcp"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/sink"
+	"io"
+	"strings"
+)
+
+// ingestCounts reports how many records an ingestion wrote.
+type ingestCounts struct {
+	objects, assets, hierarchies, principals, roles, resources, bindings int
+}
+
+// ingestExport loads the Cloud Asset Inventory export found at src, a single object or every object under a prefix,
+// into the database, tagging its rows with tenantID.
+func ingestExport(ctx context.Context, database db.Storage, src, tenantID string, batchSize int) (ingestCounts, error) {
+	var counts ingestCounts
+	// Like the database options, a batch size below 1 stands for the default one.
+	if batchSize < 1 {
+		batchSize = db.DefaultBatchSize
+	}
+	source, prefix, err := sink.Open(ctx, src)
+	if err != nil {
+		return counts, err
+	}
+	defer source.Close()
+
+	keys, err := source.List(ctx, prefix)
+	if err != nil {
+		return counts, fmt.Errorf("listing %s: %v", src, err)
+	}
+	if len(keys) == 0 {
+		// Not a prefix, src may designate a single object.
+		if _, err := source.Stat(ctx, prefix); errors.Is(err, sink.ErrNotExist) {
+			return counts, fmt.Errorf("no export found at %s", src)
+		} else if err != nil {
+			return counts, err
+		}
+		keys = []string{prefix}
+	}
+
+	for _, key := range keys {
+		fmt.Printf("Ingesting %s\n", key)
+		if err := ingestObject(ctx, database, source, key, tenantID, batchSize, &counts); err != nil {
+			return counts, fmt.Errorf("ingesting %s: %v", key, err)
+		}
+		counts.objects++
+	}
+	// Exports list assets in no particular order: bindings are attributed once every object is loaded.
+	if err := database.ResolveHierarchy(ctx); err != nil {
+		return counts, fmt.Errorf("resolving hierarchy: %v", err)
+	}
+	return counts, nil
+}
+
+func ingestObject(ctx context.Context, database db.Storage, source sink.Sink, key, tenantID string, batchSize int, counts *ingestCounts) error {
+	object, err := source.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	var r io.Reader = object
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(object)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return gcp.ReadAssetExport(r, batchSize, func(assets []*assetpb.Asset) error {
+		records := gcp.AssetRecords{TenantID: tenantID}
+		for _, asset := range assets {
+			if err := records.Add(asset); err != nil {
+				return err
+			}
+		}
+		if err := database.InsertHierarchies(ctx, records.Hierarchies); err != nil {
+			return err
+		}
+		if err := database.InsertPrincipals(ctx, records.Principals); err != nil {
+			return err
+		}
+		if err := database.InsertRoles(ctx, records.Roles); err != nil {
+			return err
+		}
+		if err := database.InsertResources(ctx, records.Resources); err != nil {
+			return err
+		}
+		if err := database.InsertResourceIAMPermission(ctx, records.Bindings); err != nil {
+			return err
+		}
+		if err := database.InsertResourceAncestors(ctx, records.Ancestors); err != nil {
+			return err
+		}
+		counts.assets += len(assets)
+		counts.hierarchies += len(records.Hierarchies)
+		counts.principals += len(records.Principals)
+		counts.roles += len(records.Roles)
+		counts.resources += len(records.Resources)
+		counts.bindings += len(records.Bindings)
+		return nil
+	})
+}
+
+// ingestPredefinedRoles completes an ingestion with the roles managed by Google, fetched from the IAM API.
+func ingestPredefinedRoles(ctx context.Context, database db.Storage) error {
+	clients, err := gcp.NewClients(ctx, gcp.ClientOptions{})
+	if err != nil {
+		return err
+	}
+	defer clients.Close()
+
+	roles, err := clients.FetchPredefinedRoles(ctx)
+	if err != nil {
+		return err
+	}
+	return database.InsertRoles(ctx, roles)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testExport = `{"name":"//cloudresourcemanager.googleapis.com/organizations/1","asset_type":"cloudresourcemanager.googleapis.com/Organization","resource":{"version":"v1","data":{"displayName":"example.com","name":"organizations/1"}},"ancestors":["organizations/1"]}
+{"name":"//cloudresourcemanager.googleapis.com/folders/2","asset_type":"cloudresourcemanager.googleapis.com/Folder","resource":{"version":"v2","parent":"//cloudresourcemanager.googleapis.com/organizations/1","data":{"displayName":"eng"}},"ancestors":["folders/2","organizations/1"]}
+`
+
+func TestIngestExportBatchSize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "resource.json")
+	if err := os.WriteFile(src, []byte(testExport), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Batch sizes below 1 stand for the default one rather than panicking or buffering the whole export.
+	for _, batchSize := range []int{-1, 0, 1, db.DefaultBatchSize} {
+		database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"), db.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, err := ingestExport(context.Background(), database, src, "", batchSize)
+		database.Close()
+		if err != nil {
+			t.Fatalf("batch size %d: %v", batchSize, err)
+		}
+		if counts.assets != 2 || counts.hierarchies != 2 {
+			t.Errorf("batch size %d: ingested %d assets and %d hierarchy nodes, want 2 and 2", batchSize, counts.assets, counts.hierarchies)
+		}
+	}
+}
@@ -2,163 +2,575 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
 	"github.com/ttauveron/gcp-iam-dumper/pkg/gcp"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/sink"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
 func main() {
 
 	binaryName := filepath.Base(os.Args[0])
 	var rootCmd = &cobra.Command{
 		Use: binaryName,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return applyConfig(cmd)
+		},
 	}
+	rootCmd.PersistentFlags().StringP("config", "", "", "YAML configuration file providing flag values")
+	rootCmd.PersistentFlags().StringP("profile", "", "", "Profile of the configuration file to apply")
 
 	var cmdDump = &cobra.Command{
 		Use:   "dump",
-		Short: "Dump IAM data into a SQLite database",
+		Short: "Dump IAM data into a SQLite or PostgreSQL database",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("Performing load operation")
 
 			quotaProjectId, _ := cmd.Flags().GetString("quotaProjectId")
-			workspaceOrgId, _ := cmd.Flags().GetString("workspaceOrgId")
-			gcpOrgId, _ := cmd.Flags().GetString("gcpOrgId")
-			sqliteFile, _ := cmd.Flags().GetString("sqliteFile")
+			parallelism, _ := cmd.Flags().GetInt("parallelism")
+			resumeID, _ := cmd.Flags().GetString("resume")
+			keepUnchanged, _ := cmd.Flags().GetBool("keepUnchangedBindings")
+			params := dumpParameters{Tenants: dumpTenants(cmd), Collectors: dumpCollectors(cmd), ReadTime: readTime(cmd)}
+			if keepUnchanged && !params.collects("bindings") {
+				log.Fatalf("--keepUnchangedBindings requires the bindings collector")
+			}
+			for _, t := range params.Tenants {
+				if t.WorkspaceOrgID != "" && quotaProjectId == "" {
+					log.Fatalf("--quotaProjectId is required to collect the Workspace organization of tenant %s", t.ID)
+				}
+			}
 
 			ctx := context.Background()
-			database, err := db.InitDB(sqliteFile)
+			database, err := db.InitDB(databaseURL(cmd), databaseOptions(cmd))
 			if err != nil {
 				log.Fatalf("Failed to initialize database: %v", err)
 			}
 			defer database.Close()
 
-			fmt.Printf("Syncing Roles...\n")
-			syncRoles(ctx, database, gcpOrgId)
-			fmt.Printf("Syncing GroupAndMembers...\n")
-			syncGroupAndMembers(ctx, database, quotaProjectId, workspaceOrgId)
-			fmt.Printf("Syncing Hierarchy...\n")
-			syncHierarchy(ctx, database, gcpOrgId)
-			fmt.Printf("Syncing Service Accounts...\n")
-			syncServiceAccounts(ctx, database, gcpOrgId)
-			fmt.Printf("Syncing Bindings...\n")
-			syncBindings(ctx, database, gcpOrgId)
+			d := &dumper{database: database}
+			runID, params, err := d.begin(ctx, resumeID, params, keepUnchanged)
+			if err != nil {
+				log.Fatalf("Failed to start run: %v", err)
+			}
+			fmt.Printf("Run ID: %s\n", runID)
+			if params.ReadTime != nil {
+				fmt.Printf("Reading assets as of %s. Predefined roles, permissions, tags, the ancestors of the scopes and Workspace users and groups can't be read in the past and are collected as of now.\n", params.ReadTime.Format(time.RFC3339))
+			}
+
+			opts := clientOptions(cmd, quotaProjectId)
+			if params.ReadTime != nil {
+				opts.ReadTime = *params.ReadTime
+			}
+			clients, err := gcp.NewClients(ctx, opts)
+			if err != nil {
+				log.Fatalf("Failed to create API clients: %v", err)
+			}
+			defer clients.Close()
+			d.clients = clients
+
+			err = d.run(ctx, runID, params, parallelism)
+			printCallStats(clients.Stats())
+			status := db.RunCompleted
+			if err != nil {
+				status = db.RunFailed
+			}
+			if statusErr := database.SetRunStatus(ctx, runID, status); statusErr != nil {
+				log.Printf("Failed to record the status of run %s: %v", runID, statusErr)
+			}
+			if err != nil {
+				log.Fatalf("Dump failed: %v\nResume it with --resume %s", err, runID)
+			}
+		},
+	}
+	cmdDump.Flags().StringP("quotaProjectId", "", "", "The quota project ID used for Directory API/Cloud Identity API (mandatory when collecting a Workspace organization)")
+	cmdDump.Flags().StringP("workspaceOrgId", "", "", "Workspace organization ID, users and groups are not collected without it")
+	cmdDump.Flags().StringP("gcpOrgId", "", "", "GCP organization ID, shorthand for --scope organizations/<id>")
+	cmdDump.Flags().StringArrayP("tenant", "", nil, "Tenant to collect, as id=<id>,gcpOrgId=<id>,scope=<scope>,workspaceOrgId=<id> with scope repeatable and id optional (repeatable, replaces --gcpOrgId, --scope and --workspaceOrgId)")
+	cmdDump.Flags().StringArrayP("scope", "", nil, "Organization, folder or project the Asset collectors run on, as organizations/<id>, folders/<id> or projects/<id> (repeatable)")
+	addDatabaseFlags(cmdDump)
+	cmdDump.Flags().IntP("batchSize", "", db.DefaultBatchSize, "Maximum number of rows per INSERT statement (SQLite) and of collected rows buffered before being written")
+	cmdDump.Flags().StringSliceP("collectors", "", nil, "Collectors to run among "+strings.Join(collectorNames, ", ")+" (default: all)")
+	cmdDump.Flags().IntP("parallelism", "", 5, "Maximum number of collectors running concurrently")
+	cmdDump.Flags().IntP("membershipWorkers", "", gcp.DefaultMembershipWorkers, "Number of groups whose members are listed concurrently")
+	cmdDump.Flags().StringToStringP("rateLimit", "", nil, "Maximum requests per second per API (api=rps,...), overriding the defaults asset=5,iam=10,cloudidentity=20,directory=20,cloudresourcemanager=10; 0 disables the limit")
+	addRetryFlags(cmdDump)
+	cmdDump.Flags().StringP("resume", "", "", "ID of an interrupted run to resume from its last checkpoints instead of starting over")
+	cmdDump.Flags().BoolP("keepUnchangedBindings", "", false, "Keep the bindings of resources whose IAM policy didn't change since the last completed run and only rewrite the others; every policy is still listed")
+	cmdDump.Flags().StringP("readTime", "", "", "Read Asset API data as of this RFC3339 time, within the last 35 days, instead of now; predefined roles, permissions, tags, the ancestors of the scopes and Workspace data are still read as of now")
+	cmdDump.MarkFlagsMutuallyExclusive("resume", "keepUnchangedBindings")
+	cmdDump.MarkFlagsMutuallyExclusive("readTime", "keepUnchangedBindings")
+	cmdDump.MarkFlagsOneRequired("gcpOrgId", "scope", "tenant")
+	for _, flag := range []string{"gcpOrgId", "scope", "workspaceOrgId"} {
+		cmdDump.MarkFlagsMutuallyExclusive("tenant", flag)
+	}
+
+	var cmdIngest = &cobra.Command{
+		Use:   "ingest",
+		Short: "Load a Cloud Asset Inventory export into a SQLite or PostgreSQL database",
+		Run: func(cmd *cobra.Command, args []string) {
+			src, _ := cmd.Flags().GetString("src")
+			batchSize, _ := cmd.Flags().GetInt("batchSize")
+			predefinedRoles, _ := cmd.Flags().GetBool("predefinedRoles")
+			tenantID, _ := cmd.Flags().GetString("tenant")
+
+			ctx := context.Background()
+			database, err := db.InitDB(databaseURL(cmd), databaseOptions(cmd))
+			if err != nil {
+				log.Fatalf("Failed to initialize database: %v", err)
+			}
+			defer database.Close()
+
+			// Ingestions are recorded as runs too, so that a dump keeping unchanged bindings doesn't build on their data.
+			d := &dumper{database: database}
+			runID, _, err := d.begin(ctx, "", dumpParameters{Source: src, SourceTenant: tenantID}, false)
+			if err != nil {
+				log.Fatalf("Failed to start run: %v", err)
+			}
+
+			counts, err := ingestExport(ctx, database, src, tenantID, batchSize)
+			if err == nil && predefinedRoles {
+				err = ingestPredefinedRoles(ctx, database)
+			}
+			status := db.RunCompleted
+			if err != nil {
+				status = db.RunFailed
+			}
+			if statusErr := database.SetRunStatus(ctx, runID, status); statusErr != nil {
+				log.Printf("Failed to record the status of run %s: %v", runID, statusErr)
+			}
+			if err != nil {
+				log.Fatalf("Ingestion failed: %v", err)
+			}
+			fmt.Printf("Ingested %d assets from %d objects: %d resources, %d hierarchy nodes, %d service accounts, %d custom roles, %d bindings\n",
+				counts.assets, counts.objects, counts.resources, counts.hierarchies, counts.principals, counts.roles, counts.bindings)
 		},
 	}
-	cmdDump.Flags().StringP("quotaProjectId", "", "", "The quota project ID used for Directory API/Cloud Identity API (mandatory)")
-	cmdDump.Flags().StringP("workspaceOrgId", "", "", "Workspace organization ID (mandatory)")
-	cmdDump.Flags().StringP("gcpOrgId", "", "", "GCP organization ID (mandatory)")
-	cmdDump.Flags().StringP("sqliteFile", "", "./database.db", "Path to the SQLite file for CSV export")
-	cmdDump.MarkFlagRequired("quotaProjectId")
-	cmdDump.MarkFlagRequired("workspaceOrgId")
-	cmdDump.MarkFlagRequired("gcpOrgId")
+	cmdIngest.Flags().StringP("src", "", "", "Export to load: local path or gs://, s3://, sftp:// URL of an object or of a prefix holding several (mandatory)")
+	addDatabaseFlags(cmdIngest)
+	cmdIngest.Flags().IntP("batchSize", "", db.DefaultBatchSize, "Number of assets converted and written at once, and maximum number of rows per INSERT statement (SQLite)")
+	cmdIngest.Flags().BoolP("predefinedRoles", "", false, "Also fetch the predefined roles from the IAM API, which exports don't contain")
+	cmdIngest.Flags().StringP("tenant", "", "", "ID of the tenant the ingested rows are tagged with")
+	cmdIngest.MarkFlagRequired("src")
 
 	var cmdExport = &cobra.Command{
 		Use:   "export",
-		Short: "Export SQLite database to CSV",
+		Short: "Export the database to CSV",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("Performing export operation")
-			sqliteFile, _ := cmd.Flags().GetString("sqliteFile")
 			exportDir, _ := cmd.Flags().GetString("exportDir")
-			err := db.ListTablesAndDump(sqliteFile, exportDir)
+			database := openDatabase(cmd)
+			defer database.Close()
+			err := db.ListTablesAndDump(context.Background(), database, exportDir)
 			if err != nil {
 				log.Fatalf("Failed to export tables to csv files: %v", err)
 			}
 		},
 	}
-	cmdExport.Flags().StringP("sqliteFile", "", "./database.db", "Path to the SQLite file for CSV export")
+	addDatabaseFlags(cmdExport)
 	cmdExport.Flags().StringP("exportDir", "", "./export", "Directory used to dump CSV exports")
 
+	var cmdTree = &cobra.Command{
+		Use:   "tree",
+		Short: "Print the resource hierarchy with the number of bindings of every node",
+		Run: func(cmd *cobra.Command, args []string) {
+			var filter db.TreeFilter
+			filter.Principal, _ = cmd.Flags().GetString("principal")
+			filter.Role, _ = cmd.Flags().GetString("role")
+			database := openDatabase(cmd)
+			defer database.Close()
+			nodes, err := database.HierarchyTree(context.Background(), filter)
+			if err != nil {
+				log.Fatalf("Failed to read hierarchy: %v", err)
+			}
+			if len(nodes) == 0 {
+				log.Fatalf("No hierarchy in the database, run a dump or an ingestion first")
+			}
+			roots := buildTree(nodes)
+			filtered := filter.Principal != "" || filter.Role != ""
+			if filtered && !slices.ContainsFunc(roots, func(n *treeNode) bool { return n.total > 0 }) {
+				fmt.Println("No bindings match")
+				return
+			}
+			printTree(os.Stdout, roots, filtered)
+		},
+	}
+	addDatabaseFlags(cmdTree)
+	cmdTree.Flags().StringP("principal", "", "", "Only count the bindings of this principal, e.g. alice@example.com, and of the groups it is a member of")
+	cmdTree.Flags().StringP("role", "", "", "Only count the bindings of this role, e.g. roles/owner")
+
+	var cmdReport = &cobra.Command{
+		Use:   "report [name]",
+		Short: "Print a report over the database as CSV, or list the reports",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				for _, report := range db.Reports {
+					fmt.Printf("%s\t%s\n", report.Name, report.Description)
+				}
+				return
+			}
+			database := openDatabase(cmd)
+			defer database.Close()
+			if err := db.WriteReport(database, args[0], os.Stdout); err != nil {
+				log.Fatalf("Failed to run report: %v", err)
+			}
+		},
+	}
+	addDatabaseFlags(cmdReport)
+
 	var cmdUpload = &cobra.Command{
 		Use:   "upload",
-		Short: "Upload files to GCS",
+		Short: "Upload files to GCS, S3, SFTP or a local directory",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("Performing upload operation")
 			srcPath, _ := cmd.Flags().GetString("srcPath")
-			bucketName, _ := cmd.Flags().GetString("bucketName")
+			gcpOrgId, _ := cmd.Flags().GetString("gcpOrgId")
+			snapshotId, _ := cmd.Flags().GetString("snapshotId")
+			parallelism, _ := cmd.Flags().GetInt("parallelism")
+			metadata, _ := cmd.Flags().GetStringToString("metadata")
+
+			now := time.Now().UTC()
+			if snapshotId == "" {
+				snapshotId = now.Format("20060102T150405Z")
+			}
+			opts := sink.UploadOptions{
+				OrgID:       gcpOrgId,
+				Date:        now.Format("2006-01-02"),
+				SnapshotID:  snapshotId,
+				Parallelism: parallelism,
+				Metadata:    metadata,
+				ToolVersion: version,
+			}
+			ctx := context.Background()
+			dest, prefix := openDestination(ctx, cmd, opts)
+			defer dest.Close()
+			if err := sink.Upload(ctx, dest, prefix, srcPath, opts); err != nil {
+				log.Fatalf("Failed to upload files: %v", err)
+			}
+		},
+	}
+	cmdUpload.Flags().StringP("dest", "", "", "Destination URL (gs://bucket/prefix, s3://bucket/prefix, sftp://user@host/path, file:///path), may contain {orgId}, {date} and {snapshotId}")
+	cmdUpload.Flags().StringP("bucketName", "", "", "GCS Bucket name where files are uploaded, when --dest is not set")
+	cmdUpload.Flags().StringP("srcPath", "", "./export", "Path to upload, can be a file or a directory (recursive)")
+	cmdUpload.Flags().StringP("prefix", "", "", "Object name prefix used with --bucketName, may contain {orgId}, {date} and {snapshotId}")
+	cmdUpload.Flags().StringP("gcpOrgId", "", "", "GCP organization ID substituted for {orgId}")
+	cmdUpload.Flags().StringP("snapshotId", "", "", "Snapshot ID substituted for {snapshotId} (default: current UTC timestamp)")
+	cmdUpload.Flags().IntP("parallelism", "", 4, "Number of files uploaded concurrently")
+	cmdUpload.Flags().StringToStringP("metadata", "", nil, "Custom metadata set on uploaded objects (key=value,...)")
+	cmdUpload.MarkFlagsOneRequired("dest", "bucketName")
+	cmdUpload.MarkFlagsMutuallyExclusive("dest", "bucketName")
+
+	var cmdVerify = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify uploaded files against their manifest",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("Performing verify operation")
 			ctx := context.Background()
-			err := gcp.UploadFilesToGCS(ctx, bucketName, srcPath)
+			dest, prefix := openDestination(ctx, cmd, sink.UploadOptions{})
+			defer dest.Close()
+			manifest, problems, err := sink.VerifyManifest(ctx, dest, prefix)
 			if err != nil {
-				log.Fatalf("Failed to upload files to GCS: %v", err)
+				log.Fatalf("Failed to verify manifest: %v", err)
 			}
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if len(problems) > 0 {
+				log.Fatalf("%d of %d objects failed verification", len(problems), len(manifest.Objects))
+			}
+			fmt.Printf("Verified %d objects of snapshot %s\n", len(manifest.Objects), manifest.SnapshotID)
+		},
+	}
+	cmdVerify.Flags().StringP("dest", "", "", "Destination URL the files were uploaded to, after variable expansion")
+	cmdVerify.Flags().StringP("bucketName", "", "", "GCS Bucket name where files were uploaded, when --dest is not set")
+	cmdVerify.Flags().StringP("prefix", "", "", "Object name prefix used with --bucketName, after variable expansion")
+	cmdVerify.MarkFlagsOneRequired("dest", "bucketName")
+	cmdVerify.MarkFlagsMutuallyExclusive("dest", "bucketName")
+
+	var cmdPublish = &cobra.Command{
+		Use:   "publish",
+		Short: "Publish the database to an analytics destination",
+	}
+
+	var cmdPublishBigQuery = &cobra.Command{
+		Use:   "bigquery",
+		Short: "Append the database tables to BigQuery tables partitioned by snapshot date",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("Performing BigQuery publish operation")
+			projectId, _ := cmd.Flags().GetString("projectId")
+			dataset, _ := cmd.Flags().GetString("dataset")
+			location, _ := cmd.Flags().GetString("location")
+			snapshotDate, _ := cmd.Flags().GetString("snapshotDate")
+			endpoint, _ := cmd.Flags().GetString("endpoint")
+
+			date := time.Now().UTC()
+			if snapshotDate != "" {
+				var err error
+				if date, err = time.Parse("2006-01-02", snapshotDate); err != nil {
+					log.Fatalf("Invalid snapshot date %s: %v", snapshotDate, err)
+				}
+			}
+
+			database := openDatabase(cmd)
+			defer database.Close()
+
+			ctx := context.Background()
+			err := gcp.PublishToBigQuery(ctx, database, gcp.BigQueryOptions{
+				ProjectID:    projectId,
+				DatasetID:    dataset,
+				Location:     location,
+				SnapshotDate: date,
+				Endpoint:     endpoint,
+			})
+			if err != nil {
+				log.Fatalf("Failed to publish tables to BigQuery: %v", err)
+			}
+		},
+	}
+	addDatabaseFlags(cmdPublishBigQuery)
+	cmdPublishBigQuery.Flags().StringP("projectId", "", "", "Project ID of the BigQuery dataset (mandatory)")
+	cmdPublishBigQuery.Flags().StringP("dataset", "", "", "BigQuery dataset ID, created if missing (mandatory)")
+	cmdPublishBigQuery.Flags().StringP("location", "", "", "Location of the BigQuery dataset when it is created")
+	cmdPublishBigQuery.Flags().StringP("snapshotDate", "", "", "Partition date of the published rows, YYYY-MM-DD (default: current UTC date)")
+	cmdPublishBigQuery.Flags().StringP("endpoint", "", "", "BigQuery API endpoint override, e.g. http://localhost:9050 for a local emulator")
+	cmdPublishBigQuery.MarkFlagRequired("projectId")
+	cmdPublishBigQuery.MarkFlagRequired("dataset")
+	cmdPublish.AddCommand(cmdPublishBigQuery)
+
+	var cmdDB = &cobra.Command{
+		Use:   "db",
+		Short: "Manage the database schema",
+	}
+
+	var cmdDBMigrate = &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending schema migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			database := openDatabase(cmd)
+			defer database.Close()
+			if err := database.Migrate(context.Background()); err != nil {
+				log.Fatalf("Failed to migrate database: %v", err)
+			}
+			fmt.Println("Database schema is up to date")
+		},
+	}
+	addDatabaseFlags(cmdDBMigrate)
+
+	var cmdDBStatus = &cobra.Command{
+		Use:   "status",
+		Short: "Show applied and pending schema migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			database := openDatabase(cmd)
+			defer database.Close()
+			statuses, err := database.MigrationStatus(context.Background())
+			if err != nil {
+				log.Fatalf("Failed to read migration status: %v", err)
+			}
+			for _, status := range statuses {
+				applied := "pending"
+				if status.AppliedAt != nil {
+					applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+			}
+		},
+	}
+	addDatabaseFlags(cmdDBStatus)
+	cmdDB.AddCommand(cmdDBMigrate, cmdDBStatus)
+
+	var cmdConfig = &cobra.Command{
+		Use:   "config",
+		Short: "Manage the configuration file",
+	}
+
+	var cmdConfigValidate = &cobra.Command{
+		Use:         "validate",
+		Short:       "Check the configuration file against the flags of every command",
+		Annotations: map[string]string{ignoreConfigAnnotation: ""},
+		Run: func(cmd *cobra.Command, args []string) {
+			path, _ := cmd.Flags().GetString("config")
+			if path == "" {
+				path = os.Getenv(envName("config"))
+			}
+			profile, _ := cmd.Flags().GetString("profile")
+			if profile == "" {
+				profile = os.Getenv(envName("profile"))
+			}
+			if path == "" {
+				log.Fatalf("No configuration file given, set --config or %s", envName("config"))
+			}
+			c, err := loadConfig(path)
+			if err != nil {
+				log.Fatalf("Failed to load configuration: %v", err)
+			}
+			problems := validateConfig(cmd.Root(), c, profile)
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if len(problems) > 0 {
+				log.Fatalf("%d problems found in %s", len(problems), path)
+			}
+			fmt.Printf("Configuration %s is valid\n", path)
 		},
 	}
-	cmdUpload.Flags().StringP("bucketName", "", "", "GCS Bucket name where files are uploaded")
-	cmdUpload.Flags().StringP("srcPath", "", "./export", "Path to upload, can be a file or a directory (non-recursive)")
-	cmdUpload.MarkFlagRequired("bucketName")
+	cmdConfig.AddCommand(cmdConfigValidate)
 
-	rootCmd.AddCommand(cmdDump, cmdExport, cmdUpload)
+	rootCmd.AddCommand(cmdDump, cmdIngest, cmdTree, cmdReport, cmdExport, cmdUpload, cmdVerify, cmdPublish, cmdDB, cmdConfig)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
-func syncRoles(ctx context.Context, database *sql.DB, gcpOrganizationID string) {
-	roles, err := gcp.FetchAllRoles(ctx, gcpOrganizationID)
-	if err != nil {
-		log.Fatalf("Failed to fetch roles: %v", err)
+// addDatabaseFlags registers the flags selecting the database a command works on.
+func addDatabaseFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("db", "", "./database.db", "Database to use: path to a SQLite file or postgres:// URL")
+	cmd.Flags().StringP("sqliteFile", "", "", "Path to the SQLite file")
+	cmd.Flags().MarkDeprecated("sqliteFile", "use --db instead")
+	cmd.MarkFlagsMutuallyExclusive("db", "sqliteFile")
+}
+
+// databaseURL returns the database selected by --db, or by the deprecated --sqliteFile.
+func databaseURL(cmd *cobra.Command) string {
+	if sqliteFile, _ := cmd.Flags().GetString("sqliteFile"); sqliteFile != "" {
+		return sqliteFile
 	}
-	if err := db.InsertRoles(database, roles); err != nil {
-		log.Fatalf("Failed to insert roles: %v", err)
+	dsn, _ := cmd.Flags().GetString("db")
+	return dsn
+}
+
+// databaseOptions returns the database tuning selected by the command flags.
+func databaseOptions(cmd *cobra.Command) db.Options {
+	var opts db.Options
+	if cmd.Flags().Lookup("batchSize") != nil {
+		opts.BatchSize, _ = cmd.Flags().GetInt("batchSize")
 	}
+	return opts
 }
 
-func syncBindings(ctx context.Context, database *sql.DB, gcpOrganizationID string) {
-	bindings, err := gcp.FetchAssetIAMPolicy(ctx, gcpOrganizationID)
+// readTime returns the time selected by --readTime, or nil if the flag is not set.
+func readTime(cmd *cobra.Command) *time.Time {
+	value, _ := cmd.Flags().GetString("readTime")
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
 	if err != nil {
-		log.Fatalf("Error listing GCP bindings: %v", err)
+		log.Fatalf("Invalid --readTime, expected an RFC3339 time such as 2024-03-01T12:00:00Z: %v", err)
+	}
+	if t.After(time.Now()) {
+		log.Fatalf("--readTime %s is in the future", value)
 	}
-	if err := db.InsertResourceIAMPermission(database, bindings); err != nil {
-		log.Fatalf("Failed to insert bindings: %v", err)
+	if time.Since(t) > gcp.MaxReadTimeAge {
+		log.Fatalf("--readTime %s is older than the %d days of history kept by the Asset API", value, int(gcp.MaxReadTimeAge.Hours()/24))
 	}
+	t = t.UTC()
+	return &t
 }
 
-func syncServiceAccounts(ctx context.Context, database *sql.DB, gcpOrganizationID string) {
-	serviceAccounts, err := gcp.FetchServiceAccounts(ctx, gcpOrganizationID)
-	if err != nil {
-		log.Fatalf("Error listing GCP service accounts: %v", err)
+// defaultRateLimits are the requests per second sent to each API unless overridden with --rateLimit.
+var defaultRateLimits = map[string]float64{
+	gcp.AssetAPI:           5,
+	gcp.IAMAPI:             10,
+	gcp.CloudIdentityAPI:   20,
+	gcp.DirectoryAPI:       20,
+	gcp.ResourceManagerAPI: 10,
+}
+
+// clientOptions returns the API client settings selected by the command flags.
+func clientOptions(cmd *cobra.Command, quotaProjectId string) gcp.ClientOptions {
+	opts := gcp.ClientOptions{QuotaProjectID: quotaProjectId, RateLimits: map[string]float64{}}
+	opts.MembershipWorkers, _ = cmd.Flags().GetInt("membershipWorkers")
+	opts.Retry = retryPolicy(cmd)
+
+	for api, rps := range defaultRateLimits {
+		opts.RateLimits[api] = rps
 	}
-	if err := db.InsertPrincipals(database, serviceAccounts); err != nil {
-		log.Fatalf("Failed to insert service accounts: %v", err)
+	rateLimits, _ := cmd.Flags().GetStringToString("rateLimit")
+	for api, value := range rateLimits {
+		if _, ok := defaultRateLimits[api]; !ok {
+			log.Fatalf("Unknown API %q in --rateLimit", api)
+		}
+		rps, err := strconv.ParseFloat(value, 64)
+		if err != nil || rps < 0 {
+			log.Fatalf("Invalid rate limit %q for API %s", value, api)
+		}
+		opts.RateLimits[api] = rps
 	}
+	return opts
+}
+
+// addRetryFlags registers the flags of the retry policy applied to API calls.
+func addRetryFlags(cmd *cobra.Command) {
+	policy := gcp.DefaultRetryPolicy
+	cmd.Flags().IntP("retryMaxAttempts", "", policy.MaxAttempts, "Maximum number of attempts of an API call, the first one included")
+	cmd.Flags().DurationP("retryInitialBackoff", "", policy.InitialBackoff, "Delay before the first retry of an API call")
+	cmd.Flags().DurationP("retryMaxBackoff", "", policy.MaxBackoff, "Maximum delay between two attempts of an API call")
+	cmd.Flags().Float64P("retryMultiplier", "", policy.Multiplier, "Factor applied to the delay between attempts after each retry")
+	cmd.Flags().StringSliceP("retryCodes", "", gcp.CodeNames(policy.RetryableCodes), "gRPC codes of the errors retried; REST errors are mapped from their HTTP status (429: RESOURCE_EXHAUSTED, 503: UNAVAILABLE, ...)")
 }
 
-func syncGroupAndMembers(ctx context.Context, database *sql.DB, projectId string, organizationID string) {
-	users, err := gcp.FetchUsers(ctx, projectId, organizationID)
+// retryPolicy returns the retry policy selected by the command flags.
+func retryPolicy(cmd *cobra.Command) gcp.RetryPolicy {
+	var policy gcp.RetryPolicy
+	policy.MaxAttempts, _ = cmd.Flags().GetInt("retryMaxAttempts")
+	policy.InitialBackoff, _ = cmd.Flags().GetDuration("retryInitialBackoff")
+	policy.MaxBackoff, _ = cmd.Flags().GetDuration("retryMaxBackoff")
+	policy.Multiplier, _ = cmd.Flags().GetFloat64("retryMultiplier")
+	retryCodes, _ := cmd.Flags().GetStringSlice("retryCodes")
+	codes, err := gcp.ParseCodes(retryCodes)
 	if err != nil {
-		log.Fatalf("Error listing Users: %v", err)
-	}
-	if err := db.InsertPrincipals(database, users); err != nil {
-		log.Fatalf("Failed to insert users: %v", err)
+		log.Fatalf("Invalid --retryCodes: %v", err)
 	}
+	policy.RetryableCodes = codes
+	return policy
+}
 
-	groups, err := gcp.FetchGroups(ctx, projectId, organizationID)
-	if err := db.InsertPrincipals(database, groups); err != nil {
-		log.Fatalf("Failed to insert groups: %v", err)
+// printCallStats prints the calls made to each API method, and how many attempts they took.
+func printCallStats(stats []gcp.APIStats) {
+	for _, api := range stats {
+		for _, call := range api.Calls {
+			fmt.Printf("%s %s: %d calls, %d attempts, %d failed\n", api.API, call.Method, call.Calls, call.Attempts, call.Failures)
+		}
 	}
+}
 
-	principalRelationships, principals, err := gcp.FetchGroupsMembership(ctx, groups, projectId)
-	if err := db.InsertPrincipalRelationships(database, principalRelationships); err != nil {
-		log.Fatalf("Failed to insert principalRelationships: %v", err)
-	}
-	// In case there are external users, we need to track them too
-	if err := db.InsertPrincipals(database, principals); err != nil {
-		log.Fatalf("Failed to insert principals: %v", err)
+// openDatabase opens the database selected by the command flags, leaving its schema untouched.
+func openDatabase(cmd *cobra.Command) db.Storage {
+	database, err := db.Open(databaseURL(cmd), databaseOptions(cmd))
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
 	}
-
+	return database
 }
 
-func syncHierarchy(ctx context.Context, database *sql.DB, gcpOrganizationID string) {
-	hierarchies, err := gcp.FetchHierarchies(ctx, gcpOrganizationID)
+// openDestination opens the sink designated by --dest, or by --bucketName and --prefix, and returns it
+// along with the key prefix, template variables expanded.
+func openDestination(ctx context.Context, cmd *cobra.Command, opts sink.UploadOptions) (sink.Sink, string) {
+	dest, _ := cmd.Flags().GetString("dest")
+	if dest == "" {
+		bucketName, _ := cmd.Flags().GetString("bucketName")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		dest = "gs://" + bucketName + "/" + strings.TrimPrefix(prefix, "/")
+	}
+	dest, err := sink.ExpandPrefix(dest, opts)
 	if err != nil {
-		log.Fatalf("Error listing GCP hierarchies: %v", err)
+		log.Fatalf("Invalid destination: %v", err)
 	}
-	if err := db.InsertHierarchies(database, hierarchies); err != nil {
-		log.Fatalf("Failed to insert hierarchies: %v", err)
+	s, prefix, err := sink.Open(ctx, dest)
+	if err != nil {
+		log.Fatalf("Failed to open destination %s: %v", sink.Redact(dest), err)
 	}
+	return s, prefix
 }
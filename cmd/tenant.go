@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/gcp"
+	"log"
+	"slices"
+	"strings"
+)
+
+// tenant is a GCP organization, or some of its folders and projects, and/or a Workspace customer. Every row a dump
+// collects from a tenant is tagged with its ID, so that several tenants can share a database.
+type tenant struct {
+	ID string `json:"id"`
+	// Scopes are the organizations, folders and projects the Asset collectors run on, sorted.
+	Scopes         []string `json:"scopes,omitempty"`
+	WorkspaceOrgID string   `json:"workspaceOrgId,omitempty"`
+}
+
+// newTenant returns the tenant made of scopes and a Workspace organization. Unless given, its ID is its
+// organization, else its first scope, else its Workspace organization.
+func newTenant(id string, scopes []string, workspaceOrgId string) tenant {
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	if id == "" {
+		id = workspaceOrgId
+		if len(scopes) > 0 {
+			id = scopes[0]
+		}
+		for _, scope := range scopes {
+			if strings.HasPrefix(scope, "organizations/") {
+				id = scope
+				break
+			}
+		}
+	}
+	return tenant{ID: id, Scopes: scopes, WorkspaceOrgID: workspaceOrgId}
+}
+
+func (t tenant) equal(other tenant) bool {
+	return t.ID == other.ID && slices.Equal(t.Scopes, other.Scopes) && t.WorkspaceOrgID == other.WorkspaceOrgID
+}
+
+// parseTenant parses the value of --tenant, comma-separated key=value pairs among id, gcpOrgId, scope (repeatable)
+// and workspaceOrgId, e.g. id=acme,gcpOrgId=123456789,workspaceOrgId=C0123abcd.
+func parseTenant(value string) (tenant, error) {
+	var id, workspaceOrgId string
+	var scopes []string
+	for _, pair := range strings.Split(value, ",") {
+		key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || v == "" {
+			return tenant{}, fmt.Errorf("invalid tenant %q, expected key=value pairs", value)
+		}
+		switch key {
+		case "id":
+			id = v
+		case "gcpOrgId":
+			scopes = append(scopes, gcp.OrganizationScope(v))
+		case "scope":
+			scope, err := gcp.ParseScope(v)
+			if err != nil {
+				return tenant{}, err
+			}
+			scopes = append(scopes, scope)
+		case "workspaceOrgId":
+			workspaceOrgId = v
+		default:
+			return tenant{}, fmt.Errorf("unknown key %q in tenant %q, expected id, gcpOrgId, scope or workspaceOrgId", key, value)
+		}
+	}
+	if len(scopes) == 0 && workspaceOrgId == "" {
+		return tenant{}, fmt.Errorf("tenant %q has neither a scope nor a Workspace organization", value)
+	}
+	return newTenant(id, scopes, workspaceOrgId), nil
+}
+
+// dumpTenants returns the tenants selected by --tenant, sorted by ID, or else the single tenant selected by
+// --gcpOrgId, --scope and --workspaceOrgId.
+func dumpTenants(cmd *cobra.Command) []tenant {
+	values, _ := cmd.Flags().GetStringArray("tenant")
+	if len(values) == 0 {
+		workspaceOrgId, _ := cmd.Flags().GetString("workspaceOrgId")
+		return []tenant{newTenant("", dumpScopes(cmd), workspaceOrgId)}
+	}
+
+	var tenants []tenant
+	for _, value := range values {
+		t, err := parseTenant(value)
+		if err != nil {
+			log.Fatalf("Invalid --tenant: %v", err)
+		}
+		if slices.ContainsFunc(tenants, func(other tenant) bool { return other.ID == t.ID }) {
+			log.Fatalf("Invalid --tenant: several tenants have the ID %s", t.ID)
+		}
+		tenants = append(tenants, t)
+	}
+	slices.SortFunc(tenants, func(a, b tenant) int { return strings.Compare(a.ID, b.ID) })
+	return tenants
+}
+
+// dumpScopes returns the scopes selected by --gcpOrgId and --scope.
+func dumpScopes(cmd *cobra.Command) []string {
+	var scopes []string
+	if gcpOrgId, _ := cmd.Flags().GetString("gcpOrgId"); gcpOrgId != "" {
+		scopes = append(scopes, gcp.OrganizationScope(gcpOrgId))
+	}
+	values, _ := cmd.Flags().GetStringArray("scope")
+	for _, value := range values {
+		scope, err := gcp.ParseScope(value)
+		if err != nil {
+			log.Fatalf("Invalid --scope: %v", err)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
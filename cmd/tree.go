@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
+	"io"
+	"sort"
+	"strings"
+)
+
+// treeNode is a node of the printed hierarchy, with the bindings attributed to it and to the nodes below.
+type treeNode struct {
+	db.HierarchyNode
+	children []*treeNode
+	total    int
+}
+
+// buildTree links the nodes to their parent and returns the top ones, those whose parent is unknown, sorted by name.
+func buildTree(nodes []db.HierarchyNode) []*treeNode {
+	// Tenants may share nodes, each of them has its own tree.
+	type key struct{ tenantID, id string }
+	byID := map[key]*treeNode{}
+	for _, n := range nodes {
+		byID[key{n.TenantID, n.ID}] = &treeNode{HierarchyNode: n}
+	}
+	var roots []*treeNode
+	for _, n := range nodes {
+		node := byID[key{n.TenantID, n.ID}]
+		if parent, ok := byID[key{n.TenantID, n.ParentID}]; ok && parent != node {
+			parent.children = append(parent.children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	visited := map[*treeNode]bool{}
+	for _, root := range roots {
+		countBindings(root, visited)
+	}
+	sortNodes(roots)
+	return roots
+}
+
+// countBindings sets the total of node and of the nodes below, each counted once should the hierarchy have cycles.
+func countBindings(node *treeNode, visited map[*treeNode]bool) int {
+	if visited[node] {
+		return 0
+	}
+	visited[node] = true
+	node.total = node.Bindings
+	for _, child := range node.children {
+		node.total += countBindings(child, visited)
+	}
+	sortNodes(node.children)
+	return node.total
+}
+
+func sortNodes(nodes []*treeNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+}
+
+// printTree writes one line per node, indented by depth. With pruned set, the nodes without any binding at or below
+// them are left out.
+func printTree(w io.Writer, roots []*treeNode, pruned bool) {
+	var print func(node *treeNode, depth int)
+	printed := map[*treeNode]bool{}
+	print = func(node *treeNode, depth int) {
+		if printed[node] || (pruned && node.total == 0) {
+			return
+		}
+		printed[node] = true
+		line := fmt.Sprintf("%s%s (%s): %d bindings", strings.Repeat("  ", depth), node.Name, node.ID, node.Bindings)
+		if len(node.children) > 0 {
+			line += fmt.Sprintf(", %d in total", node.total)
+		}
+		fmt.Fprintln(w, line)
+		for _, child := range node.children {
+			print(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		print(root, 0)
+	}
+}
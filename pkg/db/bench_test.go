@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+// generateDataSet builds a synthetic organization holding the given number of bindings, shaped like a real dump:
+// one binding per principal and role on a resource, resources spread over projects and folders.
+func generateDataSet(bindings int) ([]model.Hierarchy, []model.Principal, []model.Role, []model.ResourceIAMPermission) {
+	projects := max(bindings/100, 1)
+	folders := max(projects/10, 1)
+	principals := max(bindings/10, 1)
+	roles := 200
+
+	hierarchies := []model.Hierarchy{{ID: "organizations/1", Name: "example.com", Type: "organization"}}
+	for i := 0; i < folders; i++ {
+		hierarchies = append(hierarchies, model.Hierarchy{ID: fmt.Sprintf("folders/%d", i), Name: fmt.Sprintf("folder-%d", i), Type: "folder", ParentID: "organizations/1"})
+	}
+	for i := 0; i < projects; i++ {
+		hierarchies = append(hierarchies, model.Hierarchy{ID: fmt.Sprintf("projects/%d", i), Name: fmt.Sprintf("project-%d", i), Type: "project", ParentID: fmt.Sprintf("folders/%d", i%folders)})
+	}
+
+	generatedPrincipals := make([]model.Principal, 0, principals)
+	for i := 0; i < principals; i++ {
+		generatedPrincipals = append(generatedPrincipals, model.Principal{ID: fmt.Sprintf("user-%d", i), Name: fmt.Sprintf("user-%d@example.com", i), Type: "user"})
+	}
+
+	generatedRoles := make([]model.Role, 0, roles)
+	for i := 0; i < roles; i++ {
+		generatedRoles = append(generatedRoles, model.Role{
+			ID:          fmt.Sprintf("roles/generated.role%d", i),
+			Title:       fmt.Sprintf("Generated role %d", i),
+			Permissions: []string{fmt.Sprintf("service%d.resources.get", i), fmt.Sprintf("service%d.resources.list", i)},
+		})
+	}
+
+	permissions := make([]model.ResourceIAMPermission, 0, bindings)
+	for i := 0; i < bindings; i++ {
+		project := i % projects
+		permissions = append(permissions, model.ResourceIAMPermission{
+			ResourceID:  fmt.Sprintf("//storage.googleapis.com/projects/_/buckets/bucket-%d-%d", project, i/projects),
+			PrincipalID: generatedPrincipals[i%principals].Name,
+			RoleID:      generatedRoles[i%roles].ID,
+			AssetType:   "storage.googleapis.com/Bucket",
+			HierarchyID: fmt.Sprintf("projects/%d", project),
+		})
+	}
+	return hierarchies, generatedPrincipals, generatedRoles, permissions
+}
+
+// benchmarkInsertBindings measures how fast the bindings of a generated data set are inserted into an empty SQLite
+// database holding its hierarchy, principals and roles.
+func benchmarkInsertBindings(b *testing.B, bindings int) {
+	ctx := context.Background()
+	s := newTestStore(b)
+	hierarchies, principals, roles, permissions := generateDataSet(bindings)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := s.ClearData(ctx); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.InsertHierarchies(ctx, hierarchies); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.InsertPrincipals(ctx, principals); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.InsertRoles(ctx, roles); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := s.InsertResourceIAMPermission(ctx, permissions); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(bindings*b.N)/b.Elapsed().Seconds(), "rows/s")
+}
+
+func BenchmarkInsertBindings10k(b *testing.B) {
+	benchmarkInsertBindings(b, 10_000)
+}
+
+func BenchmarkInsertBindings1M(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping 1M bindings in short mode")
+	}
+	benchmarkInsertBindings(b, 1_000_000)
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+// hierarchyResourcePrefix turns the ID of a hierarchy node into its full resource name, as in tag_binding.
+const hierarchyResourcePrefix = "//cloudresourcemanager.googleapis.com/"
+
+// tagCondition is the condition of bindings of a resource that checks tags.
+type tagCondition struct {
+	resourceID string
+	expression string
+	tenantID   string
+}
+
+// AssessConditions evaluates the conditions referring to tags against the effective tags of the resource of their
+// binding: those attached to the resource and those inherited from its ancestors, the closest value of each key
+// winning. The results of previous assessments are cleared first.
+func (s *store) AssessConditions(ctx context.Context, evaluate func(expression string, tags []model.ResourceTag) string) error {
+	matchTag := fmt.Sprintf("FROM %s WHERE condition_expression LIKE '%%matchTag%%'", BindingsTable)
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT resource_id, condition_expression, tenant_id "+matchTag)
+	if err != nil {
+		return err
+	}
+	var conditions []tagCondition
+	for rows.Next() {
+		var c tagCondition
+		if err := rows.Scan(&c.resourceID, &c.expression, &c.tenantID); err != nil {
+			rows.Close()
+			return err
+		}
+		conditions = append(conditions, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	attached, err := s.attachedTags(ctx)
+	if err != nil {
+		return fmt.Errorf("loading tags: %v", err)
+	}
+	ancestors, err := s.closestAncestors(ctx, "SELECT resource_id "+matchTag)
+	if err != nil {
+		return fmt.Errorf("loading ancestors: %v", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "UPDATE "+BindingsTable+" SET condition_result = NULL"); err != nil {
+		return err
+	}
+	update := fmt.Sprintf("UPDATE %s SET condition_result = %s WHERE resource_id = %s AND condition_expression = %s AND tenant_id = %s",
+		BindingsTable, s.dialect.placeholder(1), s.dialect.placeholder(2), s.dialect.placeholder(3), s.dialect.placeholder(4))
+	for _, c := range conditions {
+		tags := effectiveTags(append([]string{c.resourceID}, ancestors[c.tenantID][c.resourceID]...), attached[c.tenantID])
+		if _, err := tx.ExecContext(ctx, update, evaluate(c.expression, tags), c.resourceID, c.expression, c.tenantID); err != nil {
+			return fmt.Errorf("updating conditions of %s: %v", c.resourceID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// attachedTags returns the tags attached to every resource by tenant and full name, with the ID of their key when
+// known.
+func (s *store) attachedTags(ctx context.Context) (map[string]map[string][]model.ResourceTag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tb.tenant_id, tb.resource_name, tb.tag_key, COALESCE(tv.tag_key_id, ''), tb.tag_value, tb.tag_value_id
+FROM tag_binding tb
+         LEFT JOIN tag_value tv ON tv.id = tb.tag_value_id AND tv.tenant_id = tb.tenant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[string]map[string][]model.ResourceTag{}
+	for rows.Next() {
+		var tenantID, name string
+		var tag model.ResourceTag
+		if err := rows.Scan(&tenantID, &name, &tag.Key, &tag.KeyID, &tag.Value, &tag.ValueID); err != nil {
+			return nil, err
+		}
+		if tags[tenantID] == nil {
+			tags[tenantID] = map[string][]model.ResourceTag{}
+		}
+		tags[tenantID][name] = append(tags[tenantID][name], tag)
+	}
+	return tags, rows.Err()
+}
+
+// closestAncestors returns the full resource names of the ancestors of the resources selected by the query, by tenant
+// and resource, the closest first.
+func (s *store) closestAncestors(ctx context.Context, resources string) (map[string]map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT ra.tenant_id, ra.resource_id, ra.ancestor_id, COALESCE(h.depth, 0)
+FROM %s ra
+         LEFT JOIN hierarchy h ON h.id = ra.ancestor_id AND h.tenant_id = ra.tenant_id
+WHERE ra.resource_id IN (%s)`, AncestorsTable, resources))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type ancestor struct {
+		name  string
+		depth int
+	}
+	type resource struct{ tenantID, id string }
+	byResource := map[resource][]ancestor{}
+	for rows.Next() {
+		var r resource
+		var ancestorID string
+		var depth int
+		if err := rows.Scan(&r.tenantID, &r.id, &ancestorID, &depth); err != nil {
+			return nil, err
+		}
+		byResource[r] = append(byResource[r], ancestor{hierarchyResourcePrefix + ancestorID, depth})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	closest := map[string]map[string][]string{}
+	for r, ancestors := range byResource {
+		sort.SliceStable(ancestors, func(i, j int) bool { return ancestors[i].depth > ancestors[j].depth })
+		if closest[r.tenantID] == nil {
+			closest[r.tenantID] = map[string][]string{}
+		}
+		for _, a := range ancestors {
+			closest[r.tenantID][r.id] = append(closest[r.tenantID][r.id], a.name)
+		}
+	}
+	return closest, nil
+}
+
+// effectiveTags returns the tags of the first of names, given its ancestors the closest first: a value attached to a
+// resource replaces the values of the same key inherited from further ancestors.
+func effectiveTags(names []string, attached map[string][]model.ResourceTag) []model.ResourceTag {
+	var tags []model.ResourceTag
+	keys := map[string]bool{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		var found []string
+		for _, tag := range attached[name] {
+			if !keys[tag.Key] {
+				tags = append(tags, tag)
+				found = append(found, tag.Key)
+			}
+		}
+		for _, key := range found {
+			keys[key] = true
+		}
+	}
+	return tags
+}
@@ -1,47 +1,47 @@
 package db
 
 import (
-	"database/sql"
-	"embed"
-	_ "github.com/mattn/go-sqlite3"
-	"io/fs"
-	"log"
+	"context"
+	"strings"
 )
 
-//go:embed schema.sql
-var schemaSQL embed.FS
+// DefaultBatchSize is the number of rows written per statement when Options.BatchSize is not set.
+const DefaultBatchSize = 500
 
-func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, err
-	}
+type Options struct {
+	// BatchSize is the maximum number of rows written by a single INSERT statement on SQLite.
+	BatchSize int
+}
 
-	schema, err := fs.ReadFile(schemaSQL, "schema.sql")
-	if err != nil {
-		log.Fatalf("Failed to read embedded schema.sql: %v", err)
-		return nil, err
+func (o Options) batchSize() int {
+	if o.BatchSize <= 0 {
+		return DefaultBatchSize
 	}
+	return o.BatchSize
+}
 
-	_, err = db.Exec(string(schema))
+// Open connects to the database designated by dsn without touching its schema. URLs starting with postgres:// or
+// postgresql:// select PostgreSQL, anything else is the path of a SQLite file, optionally prefixed with sqlite://.
+func Open(dsn string, opts Options) (Storage, error) {
+	return open(dsn, opts)
+}
+
+// InitDB opens the database designated by dsn and applies pending schema migrations.
+func InitDB(dsn string, opts Options) (Storage, error) {
+	s, err := open(dsn, opts)
 	if err != nil {
-		log.Printf("Error executing schema: %v", err)
 		return nil, err
 	}
-
-	pragmas := []string{
-		"PRAGMA synchronous = FULL;",
-		"PRAGMA journal_mode = WAL;",
-		"PRAGMA cache_size = -80000;",
-		"PRAGMA temp_store = MEMORY;",
+	if err := s.Migrate(context.Background()); err != nil {
+		s.Close()
+		return nil, err
 	}
+	return s, nil
+}
 
-	for _, pragma := range pragmas {
-		_, err = db.Exec(pragma)
-		if err != nil {
-			log.Fatalf("Failed to execute %s: %v", pragma, err)
-		}
+func open(dsn string, opts Options) (*store, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return openPostgres(dsn, opts)
 	}
-
-	return db, nil
+	return openSQLite(strings.TrimPrefix(dsn, "sqlite://"), opts)
 }
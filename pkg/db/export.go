@@ -1,36 +1,21 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
+	"time"
 )
 
-// listTablesAndDump dumps each table in the SQLite database to a separate CSV file.
-func ListTablesAndDump(dbPath string, exportDir string) error {
-	db, err := sql.Open("sqlite3", dbPath)
+// ListTablesAndDump dumps each table in the database to a separate CSV file.
+func ListTablesAndDump(ctx context.Context, storage Storage, exportDir string) error {
+	tables, err := storage.ListTables(ctx)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	// List all tables
-	query := `SELECT name FROM sqlite_master WHERE type='table';`
-	rows, err := db.Query(query)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	var tables []string
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return err
-		}
-		tables = append(tables, name)
-	}
 
 	_ = os.RemoveAll(exportDir)
 
@@ -43,7 +28,7 @@ func ListTablesAndDump(dbPath string, exportDir string) error {
 	for _, table := range tables {
 		fmt.Printf("Dumping table: %s\n", table)
 		outputPath := fmt.Sprintf("%s/%s.csv", exportDir, table)
-		if err := dumpTableToCSV(db, table, outputPath); err != nil {
+		if err := dumpTableToCSV(storage.DB(), table, outputPath); err != nil {
 			return err
 		}
 	}
@@ -53,31 +38,45 @@ func ListTablesAndDump(dbPath string, exportDir string) error {
 
 // dumpTableToCSV queries a table and writes its content to a CSV file.
 func dumpTableToCSV(db *sql.DB, tableName, outputPath string) error {
-	// SQLite (and most SQL databases) don't support parameterized table names or column names.
-	// Parameters can only be used where you would otherwise place a value, such as in the WHERE clause.
-	rows, err := db.Query("SELECT * FROM " + tableName)
+	file, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	if err := WriteTableCSV(db, tableName, file, true); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
 
-	cols, err := rows.Columns()
+// WriteTableCSV writes the content of a table to w as CSV, preceded by a header row if header is set.
+// Any extra values are appended to every data row.
+func WriteTableCSV(db *sql.DB, tableName string, w io.Writer, header bool, extra ...string) error {
+	// SQLite (and most SQL databases) don't support parameterized table names or column names.
+	// Parameters can only be used where you would otherwise place a value, such as in the WHERE clause.
+	return writeQueryCSV(db, "SELECT * FROM "+tableName, w, header, extra...)
+}
+
+// writeQueryCSV writes the rows returned by query to w as CSV, as WriteTableCSV does.
+func writeQueryCSV(db *sql.DB, query string, w io.Writer, header bool, extra ...string) error {
+	rows, err := db.Query(query)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	file, err := os.Create(outputPath)
+	cols, err := rows.Columns()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	writer := csv.NewWriter(w)
 
 	// Write the header row
-	if err := writer.Write(cols); err != nil {
-		return err
+	if header {
+		if err := writer.Write(cols); err != nil {
+			return err
+		}
 	}
 
 	// Write the data rows
@@ -92,17 +91,29 @@ func dumpTableToCSV(db *sql.DB, tableName, outputPath string) error {
 			return err
 		}
 
-		record := make([]string, len(cols))
+		record := make([]string, len(cols), len(cols)+len(extra))
 		for i, col := range values {
-			if col != nil {
-				record[i] = fmt.Sprintf("%v", col)
+			switch v := col.(type) {
+			case nil:
+			case []byte:
+				record[i] = string(v)
+			case time.Time:
+				record[i] = v.UTC().Format(time.RFC3339)
+			default:
+				record[i] = fmt.Sprintf("%v", v)
 			}
 		}
+		record = append(record, extra...)
 
 		if err := writer.Write(record); err != nil {
 			return err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	return nil
+	// Rows are buffered: errors writing the last of them only surface once flushed.
+	writer.Flush()
+	return writer.Error()
 }
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+// failingWriter fails every write, like a full disk or a closed pipe.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteTableCSV(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.InsertHierarchies(context.Background(), []model.Hierarchy{{ID: "organizations/1", Name: "example.com", Type: "organization", TenantID: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := WriteTableCSV(s.db, "hierarchy", &b, true, "2024-03-01"); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,name,type,parent_id,tenant_id,depth,path\norganizations/1,example.com,organization,,a,,,2024-03-01\n"
+	if b.String() != want {
+		t.Errorf("WriteTableCSV wrote %q, want %q", b.String(), want)
+	}
+
+	// A table small enough to be buffered whole only fails once flushed.
+	if err := WriteTableCSV(s.db, "hierarchy", failingWriter{}, true); err == nil {
+		t.Errorf("WriteTableCSV to a failing writer succeeded")
+	}
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+// ResolveHierarchy completes a dump once the hierarchy and the bindings are collected:
+//   - it computes the depth of every hierarchy node, 0 for the nodes whose parent is unknown, organizations usually,
+//     and its path, the names of its ancestors and its own joined with " / ";
+//   - it attributes every binding and resource to the closest of the ancestors of its resource, the deepest one, since
+//     searched policies and resources list their folders in no particular order;
+//   - it ties custom roles to the node defining them, projects being named by ID in role names.
+//
+// Ancestors missing from the hierarchy, when the caller can't see them, are left out; bindings and resources keep their
+// attribution if none of their ancestors is known.
+func (s *store) ResolveHierarchy(ctx context.Context) error {
+	nodes, err := s.hierarchyNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("loading hierarchy: %v", err)
+	}
+	bindingColumns, err := s.ListColumns(ctx, BindingsTable)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	update := fmt.Sprintf("UPDATE hierarchy SET depth = %s, path = %s WHERE id = %s AND tenant_id = %s",
+		s.dialect.placeholder(1), s.dialect.placeholder(2), s.dialect.placeholder(3), s.dialect.placeholder(4))
+	for tenantID, tenantNodes := range nodes {
+		for id, position := range hierarchyPositions(tenantNodes) {
+			if _, err := tx.ExecContext(ctx, update, position.depth, position.path, id, tenantID); err != nil {
+				return fmt.Errorf("updating depth of %s: %v", id, err)
+			}
+		}
+	}
+
+	// hierarchy_id is part of the primary key of bindings: rather than updated, misattributed bindings are copied with
+	// the right attribution, which merges duplicates, then deleted.
+	var columns, selected []string
+	for _, column := range bindingColumns {
+		columns = append(columns, column.Name)
+		if column.Name == "hierarchy_id" {
+			selected = append(selected, "n.ancestor_id")
+		} else {
+			selected = append(selected, "b."+column.Name)
+		}
+	}
+	statements := []string{
+		`CREATE TEMPORARY TABLE nearest_ancestor AS
+SELECT ra.resource_id, ra.tenant_id, MIN(ra.ancestor_id) AS ancestor_id
+FROM resource_ancestor ra
+         JOIN hierarchy h ON h.id = ra.ancestor_id AND h.tenant_id = ra.tenant_id
+WHERE h.depth = (SELECT MAX(h2.depth)
+                 FROM resource_ancestor ra2
+                          JOIN hierarchy h2 ON h2.id = ra2.ancestor_id AND h2.tenant_id = ra2.tenant_id
+                 WHERE ra2.resource_id = ra.resource_id
+                   AND ra2.tenant_id = ra.tenant_id)
+GROUP BY ra.resource_id, ra.tenant_id`,
+		// Every collected resource has a row, looked up by the statements below.
+		`CREATE INDEX nearest_ancestor_resource ON nearest_ancestor (resource_id, tenant_id)`,
+		fmt.Sprintf(`INSERT INTO %s (%s)
+SELECT %s
+FROM %s b
+         JOIN nearest_ancestor n ON n.resource_id = b.resource_id AND n.tenant_id = b.tenant_id
+WHERE b.hierarchy_id <> n.ancestor_id
+ON CONFLICT DO NOTHING`, BindingsTable, strings.Join(columns, ", "), strings.Join(selected, ", "), BindingsTable),
+		fmt.Sprintf(`DELETE FROM %s
+WHERE EXISTS (SELECT 1
+              FROM nearest_ancestor n
+              WHERE n.resource_id = %s.resource_id
+                AND n.tenant_id = %s.tenant_id
+                AND n.ancestor_id <> %s.hierarchy_id)`, BindingsTable, BindingsTable, BindingsTable, BindingsTable),
+		`UPDATE resource
+SET hierarchy_id = (SELECT n.ancestor_id
+                    FROM nearest_ancestor n
+                    WHERE n.resource_id = resource.name
+                      AND n.tenant_id = resource.tenant_id)
+WHERE EXISTS (SELECT 1 FROM nearest_ancestor n WHERE n.resource_id = resource.name AND n.tenant_id = resource.tenant_id)`,
+		`DROP TABLE nearest_ancestor`,
+		`UPDATE role
+SET hierarchy_id = (SELECT MIN(h.id)
+                    FROM hierarchy h
+                    WHERE h.tenant_id = role.tenant_id
+                      AND (h.id = role.parent OR (h.type = 'project' AND 'projects/' || h.name = role.parent)))
+WHERE parent <> ''`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("attributing bindings, resources and roles: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// hierarchyNode is the part of a hierarchy row the depth and path of a node derive from.
+type hierarchyNode struct {
+	name     string
+	parentID string
+}
+
+// hierarchyNodes returns the nodes of every tenant, by tenant and ID: the hierarchy of a tenant is resolved on its own.
+func (s *store) hierarchyNodes(ctx context.Context) (map[string]map[string]hierarchyNode, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, parent_id, tenant_id FROM hierarchy")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := map[string]map[string]hierarchyNode{}
+	for rows.Next() {
+		var id, name, tenantID string
+		var parentID sql.NullString
+		if err := rows.Scan(&id, &name, &parentID, &tenantID); err != nil {
+			return nil, err
+		}
+		if nodes[tenantID] == nil {
+			nodes[tenantID] = map[string]hierarchyNode{}
+		}
+		nodes[tenantID][id] = hierarchyNode{name: name, parentID: parentID.String}
+	}
+	return nodes, rows.Err()
+}
+
+type hierarchyPosition struct {
+	depth int
+	path  string
+}
+
+// hierarchyPositions returns the depth and path of every node. A node whose parent is not a node, or that is part of
+// a cycle, is at depth 0.
+func hierarchyPositions(nodes map[string]hierarchyNode) map[string]hierarchyPosition {
+	positions := map[string]hierarchyPosition{}
+	var position func(id string, visiting map[string]bool) hierarchyPosition
+	position = func(id string, visiting map[string]bool) hierarchyPosition {
+		if p, ok := positions[id]; ok {
+			return p
+		}
+		node := nodes[id]
+		p := hierarchyPosition{path: node.name}
+		if _, ok := nodes[node.parentID]; ok && !visiting[id] {
+			visiting[id] = true
+			parent := position(node.parentID, visiting)
+			p = hierarchyPosition{depth: parent.depth + 1, path: parent.path + " / " + node.name}
+		}
+		positions[id] = p
+		return p
+	}
+	for id := range nodes {
+		position(id, map[string]bool{})
+	}
+	return positions
+}
+
+// TreeFilter restricts the bindings counted by HierarchyTree, when its fields are set, to those of a principal,
+// including the groups it is a member of, directly or not, and to those of a role.
+type TreeFilter struct {
+	Principal string
+	Role      string
+}
+
+// HierarchyNode is a node of the hierarchy along with the number of bindings attributed to it.
+type HierarchyNode struct {
+	model.Hierarchy
+	Bindings int
+}
+
+// HierarchyTree returns every node of the hierarchy and the number of bindings matching filter attributed to each.
+func (s *store) HierarchyTree(ctx context.Context, filter TreeFilter) ([]HierarchyNode, error) {
+	var with string
+	var conditions []string
+	var args []any
+	if filter.Principal != "" {
+		with = fmt.Sprintf(`WITH RECURSIVE principals(id, name) AS (
+    SELECT id, name
+    FROM principal
+    WHERE name = %s
+    UNION
+    SELECT p.id, p.name
+    FROM principal p
+             JOIN principal_hierarchy ph ON p.id = ph.parent_id
+             JOIN principals pp ON ph.child_id = pp.id
+)
+`, s.dialect.placeholder(len(args)+1))
+		args = append(args, filter.Principal)
+		// The principal may be missing from principal, external users are only known from their bindings.
+		conditions = append(conditions, fmt.Sprintf("(b.principal_name = %s OR b.principal_name IN (SELECT name FROM principals))", s.dialect.placeholder(len(args)+1)))
+		args = append(args, filter.Principal)
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, "b.role_id = "+s.dialect.placeholder(len(args)+1))
+		args = append(args, filter.Role)
+	}
+	join := "b.hierarchy_id = h.id AND b.tenant_id = h.tenant_id"
+	for _, condition := range conditions {
+		join += " AND " + condition
+	}
+
+	query := with + fmt.Sprintf(`SELECT h.id, h.name, h.type, COALESCE(h.parent_id, ''), h.tenant_id, COUNT(b.resource_id)
+FROM hierarchy h
+         LEFT JOIN %s b ON %s
+GROUP BY h.id, h.name, h.type, h.parent_id, h.tenant_id`, BindingsTable, join)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []HierarchyNode
+	for rows.Next() {
+		var n HierarchyNode
+		if err := rows.Scan(&n.ID, &n.Name, &n.Type, &n.ParentID, &n.TenantID, &n.Bindings); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, rows.Err()
+}
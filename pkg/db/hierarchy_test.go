@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+func TestResolveHierarchy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	err := s.InsertHierarchies(ctx, []model.Hierarchy{
+		{ID: "organizations/1", Name: "example.com", Type: "organization"},
+		{ID: "folders/2", Name: "engineering", Type: "folder", ParentID: "organizations/1"},
+		{ID: "folders/3", Name: "platform", Type: "folder", ParentID: "folders/2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Searched resources and policies list their folders in no particular order, and are attributed to the first.
+	const (
+		folder = "//cloudresourcemanager.googleapis.com/folders/3"
+		sink   = "//logging.googleapis.com/folders/3/sinks/audit"
+		hidden = "//logging.googleapis.com/folders/4/sinks/audit"
+	)
+	err = s.InsertResources(ctx, []model.Resource{
+		{Name: folder, AssetType: "cloudresourcemanager.googleapis.com/Folder", HierarchyID: "folders/2", Ancestors: []string{"folders/2", "folders/3", "organizations/1"}},
+		{Name: sink, AssetType: "logging.googleapis.com/LogSink", HierarchyID: "folders/2", Ancestors: []string{"folders/2", "folders/3", "organizations/1"}},
+		// folders/4 isn't visible: the resource keeps its attribution.
+		{Name: hidden, AssetType: "logging.googleapis.com/LogSink", HierarchyID: "folders/4", Ancestors: []string{"folders/4"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.InsertResourceIAMPermission(ctx, []model.ResourceIAMPermission{
+		{ResourceID: folder, PrincipalID: "user@example.com", RoleID: "roles/viewer", AssetType: "cloudresourcemanager.googleapis.com/Folder", HierarchyID: "folders/2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ResolveHierarchy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{folder: "folders/3", sink: "folders/3", hidden: "folders/4"} {
+		var got string
+		if err := s.db.QueryRow("SELECT hierarchy_id FROM resource WHERE name = ?", name).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("resource %s is attributed to %s, want %s", name, got, want)
+		}
+	}
+	if n := count(t, s, "SELECT COUNT(*) FROM resource_role_principal WHERE hierarchy_id = 'folders/3'"); n != 1 {
+		t.Errorf("%d bindings attributed to folders/3, want 1", n)
+	}
+	var path string
+	if err := s.db.QueryRow("SELECT path FROM hierarchy WHERE id = 'folders/3'").Scan(&path); err != nil {
+		t.Fatal(err)
+	}
+	if path != "example.com / engineering / platform" {
+		t.Errorf("folders/3 path is %q", path)
+	}
+}
+
+func TestResolveHierarchyTenants(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	// Tenant a sees folders/2 under its organization, tenant b, scoped to the folder, sees it alone.
+	err := s.InsertHierarchies(ctx, []model.Hierarchy{
+		{ID: "organizations/1", Name: "example.com", Type: "organization", TenantID: "a"},
+		{ID: "folders/2", Name: "engineering", Type: "folder", ParentID: "organizations/1", TenantID: "a"},
+		{ID: "folders/2", Name: "engineering", Type: "folder", TenantID: "b"},
+		{ID: "projects/3", Name: "proj", Type: "project", ParentID: "folders/2", TenantID: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResolveHierarchy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id, tenantID string
+		depth        int
+		path         string
+	}{
+		{"organizations/1", "a", 0, "example.com"},
+		{"folders/2", "a", 1, "example.com / engineering"},
+		{"folders/2", "b", 0, "engineering"},
+		{"projects/3", "b", 1, "engineering / proj"},
+	}
+	for _, tt := range tests {
+		var depth int
+		var path string
+		if err := s.db.QueryRow("SELECT depth, path FROM hierarchy WHERE id = ? AND tenant_id = ?", tt.id, tt.tenantID).Scan(&depth, &path); err != nil {
+			t.Fatal(err)
+		}
+		if depth != tt.depth || path != tt.path {
+			t.Errorf("%s of tenant %s is at depth %d with path %q, want %d and %q", tt.id, tt.tenantID, depth, path, tt.depth, tt.path)
+		}
+	}
+}
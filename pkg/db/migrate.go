@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations holds one directory per dialect of numbered SQL files, e.g. migrations/sqlite/0002_add_column.sql.
+// Migrations are applied in order and never edited once released: schema changes go into a new file for every dialect.
+//
+//go:embed migrations
+var migrations embed.FS
+
+// MigrationStatus describes a migration and whether it has been applied to the database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations(dialectName string) ([]migration, error) {
+	dir := path.Join("migrations", dialectName)
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []migration
+	for _, entry := range entries {
+		number, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		content, err := fs.ReadFile(migrations, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, migration{version: version, name: name, sql: string(content)})
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].version < loaded[j].version })
+	for i, m := range loaded {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return loaded, nil
+}
+
+func (s *store) ensureSchemaVersionTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version
+(
+    version    INTEGER PRIMARY KEY,
+    name       TEXT      NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+func (s *store) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Migrate applies every pending migration, each in its own transaction.
+func (s *store) Migrate(ctx context.Context) error {
+	all, err := loadMigrations(s.dialect.name())
+	if err != nil {
+		return err
+	}
+	if err := s.ensureSchemaVersionTable(ctx); err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for version := range applied {
+		if version > len(all) {
+			return fmt.Errorf("database schema version %d is newer than this release supports (%d)", version, len(all))
+		}
+	}
+
+	for _, m := range all {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := s.applyMigration(ctx, m); err != nil {
+			return fmt.Errorf("applying migration %04d_%s: %v", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *store) applyMigration(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO schema_version (version, name, applied_at) VALUES (%s, %s, %s)",
+		s.dialect.placeholder(1), s.dialect.placeholder(2), s.dialect.placeholder(3))
+	if _, err := tx.ExecContext(ctx, query, m.version, m.name, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrationStatus lists every migration known to this release along with when it was applied, if it was.
+func (s *store) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	all, err := loadMigrations(s.dialect.name())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureSchemaVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(all))
+	for _, m := range all {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ClearData deletes the rows of every dumped table but the keep ones, leaving the schema and its version untouched.
+func (s *store) ClearData(ctx context.Context, keep ...string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range dataTables {
+		if slices.Contains(keep, table) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClearTenantData deletes the rows of the tenant tenantID, leaving those of other tenants untouched. Principals and the
+// permissions of roles are only deleted when no other tenant shares them. The permission catalog, which belongs to no
+// tenant, is kept, and so are predefined roles, which are stored under the empty tenant but shared by every tenant.
+func (s *store) ClearTenantData(ctx context.Context, tenantID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p := s.dialect.placeholder
+	rolePermissions := fmt.Sprintf(`DELETE FROM role_permission
+WHERE role_id IN (SELECT id FROM role WHERE tenant_id = %s AND parent <> '')
+  AND role_id NOT IN (SELECT id FROM role WHERE tenant_id <> %s)`, p(1), p(2))
+	if _, err := tx.ExecContext(ctx, rolePermissions, tenantID, tenantID); err != nil {
+		return err
+	}
+	// Principals of the empty tenant are those without any principal_tenant row.
+	principals := fmt.Sprintf(`DELETE FROM principal
+WHERE id IN (SELECT principal_id FROM principal_tenant WHERE tenant_id = %s)
+  AND id NOT IN (SELECT principal_id FROM principal_tenant WHERE tenant_id <> %s)`, p(1), p(2))
+	args := []any{tenantID, tenantID}
+	if tenantID == "" {
+		principals, args = `DELETE FROM principal WHERE id NOT IN (SELECT principal_id FROM principal_tenant)`, nil
+	}
+	if _, err := tx.ExecContext(ctx, principals, args...); err != nil {
+		return err
+	}
+	for _, table := range tenantTables {
+		query := fmt.Sprintf("DELETE FROM %s WHERE tenant_id = %s", table, p(1))
+		if table == "role" {
+			query += " AND parent <> ''"
+		}
+		if _, err := tx.ExecContext(ctx, query, tenantID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
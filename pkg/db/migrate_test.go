@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+// newTestStore returns a migrated SQLite database in a temporary directory.
+func newTestStore(t testing.TB) *store {
+	t.Helper()
+	s, err := open(filepath.Join(t.TempDir(), "test.db"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// count returns the result of a SELECT COUNT(*) query.
+func count(t *testing.T, s *store, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// insertTenant writes the same hierarchy, principals, roles, bindings, resources and tags under tenantID.
+func insertTenant(t *testing.T, s *store, tenantID string) {
+	t.Helper()
+	ctx := context.Background()
+	err := s.InsertHierarchies(ctx, []model.Hierarchy{
+		{ID: "organizations/1", Name: "example.com", Type: "organization", TenantID: tenantID},
+		{ID: "projects/2", Name: "proj", Type: "project", ParentID: "organizations/1", TenantID: tenantID},
+	})
+	if err == nil {
+		err = s.InsertPrincipals(ctx, []model.Principal{
+			{ID: "shared", Name: "user:shared@example.com", Type: "user", TenantID: tenantID},
+			{ID: "own-" + tenantID, Name: "user:" + tenantID + "@example.com", Type: "user", TenantID: tenantID},
+		})
+	}
+	if err == nil {
+		err = s.InsertPrincipalRelationships(ctx, []model.PrincipalRelationship{{ParentID: "group", ChildID: "shared", TenantID: tenantID}})
+	}
+	if err == nil {
+		err = s.InsertRoles(ctx, []model.Role{{ID: "organizations/1/roles/custom", Title: "Custom", Parent: "organizations/1", Permissions: []string{"a.b.c"}, TenantID: tenantID}})
+	}
+	if err == nil {
+		err = s.InsertResourceIAMPermission(ctx, []model.ResourceIAMPermission{
+			{ResourceID: "//cloudresourcemanager.googleapis.com/projects/2", PrincipalID: "user:shared@example.com", RoleID: "organizations/1/roles/custom", AssetType: "cloudresourcemanager.googleapis.com/Project", HierarchyID: "projects/2", TenantID: tenantID},
+		})
+	}
+	if err == nil {
+		err = s.InsertTags(ctx,
+			[]model.TagKey{{ID: "tagKeys/1", Parent: "organizations/1", ShortName: "env", NamespacedName: "1/env", TenantID: tenantID}},
+			[]model.TagValue{{ID: "tagValues/1", KeyID: "tagKeys/1", ShortName: "prod", NamespacedName: "1/env/prod", TenantID: tenantID}})
+	}
+	if err == nil {
+		err = s.InsertResources(ctx, []model.Resource{{
+			Name:        "//storage.googleapis.com/bucket",
+			AssetType:   "storage.googleapis.com/Bucket",
+			Project:     "projects/2",
+			HierarchyID: "projects/2",
+			TenantID:    tenantID,
+			Ancestors:   []string{"projects/2", "organizations/1"},
+			Tags:        []model.ResourceTag{{Key: "1/env", KeyID: "tagKeys/1", Value: "1/env/prod", ValueID: "tagValues/1"}},
+		}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClearTenantData(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	insertTenant(t, s, "a")
+	insertTenant(t, s, "b")
+	if err := s.InsertRoles(ctx, []model.Role{{ID: "roles/viewer", Title: "Viewer", Permissions: []string{"a.b.get"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows shared by tenants are kept for each of them.
+	shared := []string{"hierarchy", "principal_hierarchy", "role", "resource_role_principal", "resource", "resource_ancestor", "tag_key", "tag_value", "tag_binding"}
+	for _, table := range shared {
+		if got := count(t, s, "SELECT COUNT(*) FROM "+table+" WHERE tenant_id = 'b'"); got == 0 {
+			t.Errorf("%s has no row of tenant b", table)
+		}
+	}
+
+	if err := s.ClearTenantData(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tenantTables {
+		if got := count(t, s, "SELECT COUNT(*) FROM "+table+" WHERE tenant_id = 'b'"); got != 0 {
+			t.Errorf("%s has %d rows of tenant b left", table, got)
+		}
+	}
+	for _, table := range append(shared, "principal_tenant") {
+		if got := count(t, s, "SELECT COUNT(*) FROM "+table+" WHERE tenant_id = 'a'"); got == 0 {
+			t.Errorf("%s lost the rows of tenant a", table)
+		}
+	}
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT COUNT(*) FROM principal WHERE id = 'shared'", 1},
+		{"SELECT COUNT(*) FROM principal WHERE id = 'own-a'", 1},
+		{"SELECT COUNT(*) FROM principal WHERE id = 'own-b'", 0},
+		{"SELECT COUNT(*) FROM role WHERE tenant_id = ''", 1},
+		{"SELECT COUNT(*) FROM role_permission WHERE role_id = 'organizations/1/roles/custom'", 1},
+		{"SELECT COUNT(*) FROM role_permission WHERE role_id = 'roles/viewer'", 1},
+	}
+	for _, tt := range tests {
+		if got := count(t, s, tt.query); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+
+	// Once no tenant has it, the permissions of a role go too.
+	if err := s.ClearTenantData(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(t, s, "SELECT COUNT(*) FROM role_permission WHERE role_id = 'organizations/1/roles/custom'"); got != 0 {
+		t.Errorf("role_permission kept %d rows of the custom role", got)
+	}
+	if got := count(t, s, "SELECT COUNT(*) FROM principal WHERE id = 'shared'"); got != 0 {
+		t.Errorf("principal kept the principal of tenants a and b")
+	}
+}
+
+func TestClearTenantDataKeepsPredefinedRoles(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	// A dump without --tenant writes its rows under the empty tenant, like predefined roles.
+	insertTenant(t, s, "")
+	if err := s.InsertRoles(ctx, []model.Role{{ID: "roles/viewer", Title: "Viewer", Permissions: []string{"a.b.get"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ClearTenantData(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT COUNT(*) FROM role WHERE id = 'roles/viewer'", 1},
+		{"SELECT COUNT(*) FROM role_permission WHERE role_id = 'roles/viewer'", 1},
+		{"SELECT COUNT(*) FROM role WHERE id = 'organizations/1/roles/custom'", 0},
+		{"SELECT COUNT(*) FROM role_permission WHERE role_id = 'organizations/1/roles/custom'", 0},
+		{"SELECT COUNT(*) FROM hierarchy", 0},
+		{"SELECT COUNT(*) FROM resource_role_principal", 0},
+	}
+	for _, tt := range tests {
+		if got := count(t, s, tt.query); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+// openBaseline returns a SQLite database created by the schema.sql of releases predating migrations, holding a row in
+// every table.
+func openBaseline(t *testing.T) *store {
+	t.Helper()
+	s, err := open(filepath.Join(t.TempDir(), "baseline.db"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	schema, err := os.ReadFile(filepath.Join("testdata", "baseline_schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements := []string{
+		string(schema),
+		`INSERT INTO hierarchy (id, name, type, parent_id) VALUES ('organizations/1', 'example.com', 'organization', NULL), ('projects/2', 'proj', 'project', 'organizations/1')`,
+		`INSERT INTO principal (id, name, type) VALUES ('1', 'user:a@example.com', 'user'), ('2', 'group:g@example.com', 'group')`,
+		`INSERT INTO principal_hierarchy (parent_id, child_id) VALUES ('2', '1')`,
+		`INSERT INTO role (id, title) VALUES ('roles/viewer', 'Viewer')`,
+		`INSERT INTO role_permission (role_id, permission_id) VALUES ('roles/viewer', 'resourcemanager.projects.get')`,
+		`INSERT INTO resource_role_principal (resource_id, principal_name, role_id, conditional, asset_type, hierarchy_id)
+VALUES ('//cloudresourcemanager.googleapis.com/projects/2', 'user:a@example.com', 'roles/viewer', '', 'cloudresourcemanager.googleapis.com/Project', 'projects/2')`,
+	}
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	s := openBaseline(t)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := loadMigrations(s.dialect.name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := s.db.Query("SELECT version, name FROM schema_version ORDER BY version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var applied []migration
+	for rows.Next() {
+		var m migration
+		if err := rows.Scan(&m.version, &m.name); err != nil {
+			t.Fatal(err)
+		}
+		applied = append(applied, m)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("schema_version has %d rows, want %d", len(applied), len(all))
+	}
+	for i, m := range applied {
+		if m.version != all[i].version || m.name != all[i].name {
+			t.Errorf("schema_version row %d = %d %s, want %d %s", i, m.version, m.name, all[i].version, all[i].name)
+		}
+	}
+
+	// The rows of the baseline survive, with the defaults of the columns added since.
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT COUNT(*) FROM hierarchy WHERE tenant_id = ''", 2},
+		{"SELECT COUNT(*) FROM hierarchy WHERE id = 'projects/2' AND parent_id = 'organizations/1' AND type = 'project'", 1},
+		{"SELECT COUNT(*) FROM principal WHERE id = '1' AND name = 'user:a@example.com'", 1},
+		{"SELECT COUNT(*) FROM principal", 2},
+		{"SELECT COUNT(*) FROM principal_hierarchy WHERE parent_id = '2' AND child_id = '1' AND tenant_id = ''", 1},
+		{"SELECT COUNT(*) FROM role WHERE id = 'roles/viewer' AND title = 'Viewer' AND tenant_id = '' AND parent = '' AND NOT deleted", 1},
+		{"SELECT COUNT(*) FROM role_permission WHERE role_id = 'roles/viewer'", 1},
+		{"SELECT COUNT(*) FROM resource_role_principal WHERE hierarchy_id = 'projects/2' AND tenant_id = '' AND condition_expression = '' AND condition_result IS NULL", 1},
+	}
+	for _, tt := range tests {
+		if got := count(t, s, tt.query); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	ctx := context.Background()
+	s := openBaseline(t)
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindings := count(t, s, "SELECT COUNT(*) FROM resource_role_principal")
+
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	after, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("%d migrations after the second Migrate, want %d", len(after), len(before))
+	}
+	for i := range after {
+		if after[i].AppliedAt == nil || !after[i].AppliedAt.Equal(*before[i].AppliedAt) {
+			t.Errorf("migration %d was applied again: %v, then %v", after[i].Version, before[i].AppliedAt, after[i].AppliedAt)
+		}
+	}
+	if got := count(t, s, "SELECT COUNT(*) FROM schema_version"); got != len(before) {
+		t.Errorf("schema_version has %d rows, want %d", got, len(before))
+	}
+	if got := count(t, s, "SELECT COUNT(*) FROM resource_role_principal"); got != bindings {
+		t.Errorf("resource_role_principal has %d rows, want %d", got, bindings)
+	}
+}
@@ -0,0 +1,50 @@
+-- Tables are only created when missing, so that databases created before schema migrations existed
+-- are adopted as version 1 with their data intact.
+-- PostgreSQL flavour of the SQLite migration. Foreign keys are left out: SQLite does not enforce them either, and
+-- bindings routinely reference principals and roles that are not dumped (external users, deleted principals...).
+CREATE TABLE IF NOT EXISTS hierarchy
+(
+    id        TEXT PRIMARY KEY,
+    name      TEXT NOT NULL,
+    type      TEXT NOT NULL CHECK (type IN ('project', 'folder', 'organization')),
+    parent_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS principal
+(
+    id   TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    type TEXT NOT NULL CHECK (type IN ('user', 'group', 'serviceAccount'))
+);
+
+CREATE TABLE IF NOT EXISTS principal_hierarchy
+(
+    parent_id TEXT NOT NULL,
+    child_id  TEXT NOT NULL,
+    PRIMARY KEY (parent_id, child_id)
+);
+
+CREATE TABLE IF NOT EXISTS resource_role_principal
+(
+    resource_id    TEXT NOT NULL,
+    principal_name TEXT NOT NULL,
+    role_id        TEXT NOT NULL,
+    conditional    TEXT,
+    asset_type     TEXT NOT NULL,
+    hierarchy_id   TEXT NOT NULL,
+    PRIMARY KEY (resource_id, principal_name, role_id, conditional, hierarchy_id, asset_type)
+);
+
+CREATE TABLE IF NOT EXISTS role
+(
+    id    TEXT NOT NULL,
+    title TEXT NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS role_permission
+(
+    role_id       TEXT NOT NULL,
+    permission_id TEXT NOT NULL,
+    PRIMARY KEY (role_id, permission_id)
+);
@@ -0,0 +1,27 @@
+-- Tenants, a GCP organization and/or a Workspace customer, let several of them be dumped into the same database.
+-- Rows dumped before tenants existed belong to the empty tenant. Tenants may share hierarchy nodes, group memberships
+-- and custom roles, e.g. a project dumped as the scope of two tenants: tenant_id joins the primary key of their tables
+-- so that the rows of one tenant don't shadow those of another. The key of resource_role_principal changes along with
+-- its conditions, see 0007_tags.
+ALTER TABLE hierarchy ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE hierarchy DROP CONSTRAINT hierarchy_pkey;
+ALTER TABLE hierarchy ADD PRIMARY KEY (id, tenant_id);
+
+ALTER TABLE principal_hierarchy ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE principal_hierarchy DROP CONSTRAINT principal_hierarchy_pkey;
+ALTER TABLE principal_hierarchy ADD PRIMARY KEY (parent_id, child_id, tenant_id);
+
+-- Predefined roles are shared by every tenant and keep the empty tenant.
+ALTER TABLE role ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE role DROP CONSTRAINT role_pkey;
+ALTER TABLE role ADD PRIMARY KEY (id, tenant_id);
+
+ALTER TABLE resource_role_principal ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+-- Principals are shared by the tenants they appear in, e.g. an external user member of groups of two customers.
+CREATE TABLE principal_tenant
+(
+    principal_id TEXT NOT NULL,
+    tenant_id    TEXT NOT NULL,
+    PRIMARY KEY (principal_id, tenant_id)
+);
@@ -0,0 +1,45 @@
+-- Resource Manager tags, which IAM conditions refer to with resource.matchTag and resource.matchTagId. Like resources,
+-- tags are keyed by tenant.
+CREATE TABLE tag_key
+(
+    id              TEXT NOT NULL,
+    parent          TEXT NOT NULL,
+    short_name      TEXT NOT NULL,
+    namespaced_name TEXT NOT NULL,
+    description     TEXT,
+    tenant_id       TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, tenant_id)
+);
+
+CREATE TABLE tag_value
+(
+    id              TEXT NOT NULL,
+    tag_key_id      TEXT NOT NULL,
+    short_name      TEXT NOT NULL,
+    namespaced_name TEXT NOT NULL,
+    description     TEXT,
+    tenant_id       TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, tenant_id)
+);
+
+-- Tag values attached to a resource or hierarchy node, by its full resource name. Inherited tags are not stored: they
+-- are those of the ancestors of the resource, unless it has a value of the same key.
+CREATE TABLE tag_binding
+(
+    resource_name TEXT NOT NULL,
+    tag_key       TEXT NOT NULL,
+    tag_value     TEXT NOT NULL,
+    tag_value_id  TEXT NOT NULL,
+    tenant_id     TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (resource_name, tag_value_id, tenant_id)
+);
+
+-- The CEL expression of the condition of a binding, and whether its tag checks grant access: true, false, unknown when
+-- the rest of the condition decides, NULL when it doesn't check tags. Bindings with the same condition title but
+-- different expressions are distinct: the expression joins the primary key, along with tenant_id as in 0003_tenants.
+ALTER TABLE resource_role_principal ADD COLUMN condition_expression TEXT NOT NULL DEFAULT '';
+ALTER TABLE resource_role_principal ADD COLUMN condition_result TEXT;
+ALTER TABLE resource_role_principal DROP CONSTRAINT resource_role_principal_pkey;
+ALTER TABLE resource_role_principal
+    ADD PRIMARY KEY (resource_id, principal_name, role_id, conditional, condition_expression, hierarchy_id, asset_type,
+                     tenant_id);
@@ -0,0 +1,3 @@
+-- The hierarchy node defining a custom role. Project roles name their project by ID, e.g. projects/my-project, while
+-- hierarchy nodes are named by number.
+ALTER TABLE role ADD COLUMN hierarchy_id TEXT;
@@ -0,0 +1,14 @@
+-- IAM permissions as described by the IAM API, which role_permission.permission_id refers to. service is the prefix
+-- of the name of the permission, e.g. storage for storage.buckets.get.
+CREATE TABLE permission
+(
+    name                       TEXT PRIMARY KEY,
+    title                      TEXT,
+    description                TEXT,
+    service                    TEXT NOT NULL,
+    stage                      TEXT,
+    custom_roles_support_level TEXT,
+    api_disabled               BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX permission_service ON permission (service);
@@ -0,0 +1,20 @@
+-- Dump runs and the progress of their steps, so that an interrupted dump can be resumed.
+CREATE TABLE dump_run
+(
+    id          TEXT PRIMARY KEY,
+    parameters  TEXT      NOT NULL,
+    status      TEXT      NOT NULL CHECK (status IN ('running', 'failed', 'completed')),
+    started_at  TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE dump_checkpoint
+(
+    run_id     TEXT      NOT NULL,
+    step       TEXT      NOT NULL,
+    page_token TEXT      NOT NULL,
+    completed  BOOLEAN   NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (run_id, step),
+    FOREIGN KEY (run_id) REFERENCES dump_run (id)
+);
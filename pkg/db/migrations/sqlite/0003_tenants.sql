@@ -0,0 +1,66 @@
+-- Tenants, a GCP organization and/or a Workspace customer, let several of them be dumped into the same database.
+-- Rows dumped before tenants existed belong to the empty tenant. Tenants may share hierarchy nodes, group memberships
+-- and custom roles, e.g. a project dumped as the scope of two tenants: tenant_id joins the primary key of their tables
+-- so that the rows of one tenant don't shadow those of another. SQLite can't alter a primary key, so the tables are
+-- rebuilt. The key of resource_role_principal changes along with its conditions, see 0007_tags.
+CREATE TABLE hierarchy_new
+(
+    id        TEXT NOT NULL,
+    name      TEXT NOT NULL,
+    type      TEXT NOT NULL CHECK (type IN ('project', 'folder', 'organization')),
+    parent_id TEXT,
+    tenant_id TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, tenant_id),
+    FOREIGN KEY (parent_id, tenant_id) REFERENCES hierarchy (id, tenant_id)
+);
+
+INSERT INTO hierarchy_new (id, name, type, parent_id)
+SELECT id, name, type, parent_id
+FROM hierarchy;
+
+DROP TABLE hierarchy;
+ALTER TABLE hierarchy_new RENAME TO hierarchy;
+
+CREATE TABLE principal_hierarchy_new
+(
+    parent_id TEXT NOT NULL,
+    child_id  TEXT NOT NULL,
+    tenant_id TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (parent_id, child_id, tenant_id),
+    FOREIGN KEY (parent_id) REFERENCES principal (id),
+    FOREIGN KEY (child_id) REFERENCES principal (id)
+);
+
+INSERT INTO principal_hierarchy_new (parent_id, child_id)
+SELECT parent_id, child_id
+FROM principal_hierarchy;
+
+DROP TABLE principal_hierarchy;
+ALTER TABLE principal_hierarchy_new RENAME TO principal_hierarchy;
+
+-- Predefined roles are shared by every tenant and keep the empty tenant.
+CREATE TABLE role_new
+(
+    id        TEXT NOT NULL,
+    title     TEXT NOT NULL,
+    tenant_id TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, tenant_id)
+);
+
+INSERT INTO role_new (id, title)
+SELECT id, title
+FROM role;
+
+DROP TABLE role;
+ALTER TABLE role_new RENAME TO role;
+
+ALTER TABLE resource_role_principal ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+-- Principals are shared by the tenants they appear in, e.g. an external user member of groups of two customers.
+CREATE TABLE principal_tenant
+(
+    principal_id TEXT NOT NULL,
+    tenant_id    TEXT NOT NULL,
+    PRIMARY KEY (principal_id, tenant_id),
+    FOREIGN KEY (principal_id) REFERENCES principal (id)
+);
@@ -0,0 +1,18 @@
+-- Every resource found in the dumped scopes, whether or not it holds an IAM policy. name is the full resource name,
+-- as in resource_role_principal.resource_id, and labels a JSON object. Tenants may share resources, e.g. a project
+-- dumped as the scope of two tenants, which then have a row each.
+CREATE TABLE resource
+(
+    name         TEXT NOT NULL,
+    asset_type   TEXT NOT NULL,
+    project      TEXT,
+    location     TEXT,
+    labels       TEXT,
+    display_name TEXT,
+    state        TEXT,
+    create_time  TIMESTAMP,
+    hierarchy_id TEXT,
+    tenant_id    TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (name, tenant_id),
+    FOREIGN KEY (hierarchy_id, tenant_id) REFERENCES hierarchy (id, tenant_id)
+);
@@ -0,0 +1,15 @@
+-- The projects, folders and organization every resource belongs to, directly or not, so that bindings roll up to any
+-- level of the hierarchy.
+CREATE TABLE resource_ancestor
+(
+    resource_id TEXT NOT NULL,
+    ancestor_id TEXT NOT NULL,
+    tenant_id   TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (resource_id, ancestor_id, tenant_id),
+    FOREIGN KEY (ancestor_id, tenant_id) REFERENCES hierarchy (id, tenant_id)
+);
+
+CREATE INDEX resource_ancestor_ancestor_id ON resource_ancestor (ancestor_id);
+
+-- Distance from the top of the hierarchy, 0 for organizations, which tells the closest of the ancestors of a resource.
+ALTER TABLE hierarchy ADD COLUMN depth INTEGER;
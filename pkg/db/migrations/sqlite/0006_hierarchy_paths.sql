@@ -0,0 +1,3 @@
+-- Names of the ancestors of every node and of the node itself, from the top of the hierarchy, e.g.
+-- "example.com / engineering / my-project", to read where a node sits without a recursive query.
+ALTER TABLE hierarchy ADD COLUMN path TEXT;
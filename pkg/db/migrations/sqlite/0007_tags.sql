@@ -0,0 +1,67 @@
+-- Resource Manager tags, which IAM conditions refer to with resource.matchTag and resource.matchTagId. Like resources,
+-- tags are keyed by tenant.
+CREATE TABLE tag_key
+(
+    id              TEXT NOT NULL,
+    parent          TEXT NOT NULL,
+    short_name      TEXT NOT NULL,
+    namespaced_name TEXT NOT NULL,
+    description     TEXT,
+    tenant_id       TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, tenant_id)
+);
+
+CREATE TABLE tag_value
+(
+    id              TEXT NOT NULL,
+    tag_key_id      TEXT NOT NULL,
+    short_name      TEXT NOT NULL,
+    namespaced_name TEXT NOT NULL,
+    description     TEXT,
+    tenant_id       TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, tenant_id),
+    FOREIGN KEY (tag_key_id, tenant_id) REFERENCES tag_key (id, tenant_id)
+);
+
+-- Tag values attached to a resource or hierarchy node, by its full resource name. Inherited tags are not stored: they
+-- are those of the ancestors of the resource, unless it has a value of the same key.
+CREATE TABLE tag_binding
+(
+    resource_name TEXT NOT NULL,
+    tag_key       TEXT NOT NULL,
+    tag_value     TEXT NOT NULL,
+    tag_value_id  TEXT NOT NULL,
+    tenant_id     TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (resource_name, tag_value_id, tenant_id),
+    FOREIGN KEY (tag_value_id, tenant_id) REFERENCES tag_value (id, tenant_id)
+);
+
+-- The CEL expression of the condition of a binding, and whether its tag checks grant access: true, false, unknown when
+-- the rest of the condition decides, NULL when it doesn't check tags. Bindings with the same condition title but
+-- different expressions are distinct: the expression joins the primary key, along with tenant_id as in 0003_tenants.
+-- SQLite can't alter a primary key, so the table is rebuilt. Roles are shared with the empty tenant of predefined roles,
+-- so bindings no longer declare a foreign key to them.
+CREATE TABLE resource_role_principal_new
+(
+    resource_id          TEXT NOT NULL,
+    principal_name       TEXT NOT NULL,
+    role_id              TEXT NOT NULL,
+    conditional          TEXT,
+    asset_type           TEXT NOT NULL,
+    hierarchy_id         TEXT NOT NULL,
+    tenant_id            TEXT NOT NULL DEFAULT '',
+    condition_expression TEXT NOT NULL DEFAULT '',
+    condition_result     TEXT,
+    PRIMARY KEY (resource_id, principal_name, role_id, conditional, condition_expression, hierarchy_id, asset_type,
+                 tenant_id),
+    FOREIGN KEY (principal_name) REFERENCES principal (name),
+    FOREIGN KEY (hierarchy_id, tenant_id) REFERENCES hierarchy (id, tenant_id)
+);
+
+INSERT INTO resource_role_principal_new (resource_id, principal_name, role_id, conditional, asset_type, hierarchy_id,
+                                         tenant_id)
+SELECT resource_id, principal_name, role_id, conditional, asset_type, hierarchy_id, tenant_id
+FROM resource_role_principal;
+
+DROP TABLE resource_role_principal;
+ALTER TABLE resource_role_principal_new RENAME TO resource_role_principal;
@@ -0,0 +1,7 @@
+-- Metadata of roles, to find the bindings of deprecated, disabled or deleted roles. parent is the organization or
+-- project defining a custom role, and is empty for predefined roles.
+ALTER TABLE role ADD COLUMN description TEXT;
+ALTER TABLE role ADD COLUMN stage TEXT;
+ALTER TABLE role ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE role ADD COLUMN etag TEXT;
+ALTER TABLE role ADD COLUMN parent TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"strconv"
+	"strings"
+)
+
+type postgresDialect struct{}
+
+// openPostgres connects to PostgreSQL. Rows are streamed with COPY, so the batch size of opts does not apply.
+func openPostgres(dsn string, opts Options) (*store, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &store{db: db, dialect: postgresDialect{}}, nil
+}
+
+func (postgresDialect) name() string {
+	return "postgres"
+}
+
+func (postgresDialect) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// insertRows bulk loads rows with COPY. COPY cannot skip conflicting rows, so when conflicts are ignored the rows
+// are copied into a temporary table first and moved over with INSERT ... ON CONFLICT DO NOTHING.
+func (postgresDialect) insertRows(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]any, ignoreConflicts bool) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		tx, err := pgConn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		target := table
+		if ignoreConflicts {
+			target = "staging_" + table
+			_, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", target, table))
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{target}, columns, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+		if ignoreConflicts {
+			columnList := strings.Join(columns, ", ")
+			query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT DO NOTHING", table, columnList, columnList, target)
+			if _, err := tx.Exec(ctx, query); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+func (postgresDialect) listTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	return queryStrings(ctx, db, `SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name;`)
+}
+
+func (postgresDialect) listColumns(ctx context.Context, db *sql.DB, tableName string) ([]Column, error) {
+	return queryColumns(ctx, db, `SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position;`, tableName)
+}
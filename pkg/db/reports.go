@@ -0,0 +1,89 @@
+package db
+
+import (
+	"fmt"
+	"io"
+)
+
+// Report is a query over a dump answering a recurring question, run by the report command.
+type Report struct {
+	Name        string
+	Description string
+	Query       string
+}
+
+// Reports lists the available reports.
+var Reports = []Report{
+	{
+		Name:        "deprecated-roles",
+		Description: "Bindings of deprecated, disabled or deleted roles",
+		Query: `SELECT rrp.principal_name, rrp.role_id, r.stage, r.deleted, rrp.resource_id, rrp.hierarchy_id, rrp.tenant_id
+FROM resource_role_principal rrp
+         JOIN role r ON r.id = rrp.role_id AND r.tenant_id IN (rrp.tenant_id, '')
+WHERE r.deleted
+   OR r.stage IN ('DEPRECATED', 'DISABLED')
+ORDER BY rrp.role_id, rrp.principal_name, rrp.resource_id`,
+	},
+	{
+		Name:        "permissions-by-service",
+		Description: "Permissions per service, by launch stage and support in custom roles, and how many are granted by bindings",
+		Query: `SELECT p.service,
+       COUNT(*) AS permissions,
+       SUM(CASE WHEN p.stage = 'GA' THEN 1 ELSE 0 END) AS ga,
+       SUM(CASE WHEN p.stage = 'DEPRECATED' THEN 1 ELSE 0 END) AS deprecated,
+       SUM(CASE WHEN p.custom_roles_support_level = 'TESTING' THEN 1 ELSE 0 END) AS testing_in_custom_roles,
+       SUM(CASE WHEN p.custom_roles_support_level = 'NOT_SUPPORTED' THEN 1 ELSE 0 END) AS not_supported_in_custom_roles,
+       SUM(CASE WHEN p.api_disabled THEN 1 ELSE 0 END) AS api_disabled,
+       SUM(CASE
+               WHEN EXISTS (SELECT 1
+                            FROM role_permission rp
+                                     JOIN resource_role_principal rrp ON rrp.role_id = rp.role_id
+                            WHERE rp.permission_id = p.name) THEN 1
+               ELSE 0 END) AS granted
+FROM permission p
+GROUP BY p.service
+ORDER BY p.service`,
+	},
+	{
+		Name:        "custom-roles-testing-permissions",
+		Description: "Custom roles including permissions whose support in custom roles is still testing",
+		Query: `SELECT r.id AS role_id, r.parent, rp.permission_id, p.stage
+FROM role r
+         JOIN role_permission rp ON rp.role_id = r.id
+         JOIN permission p ON p.name = rp.permission_id
+WHERE r.parent <> ''
+  AND p.custom_roles_support_level = 'TESTING'
+ORDER BY r.id, rp.permission_id`,
+	},
+	{
+		Name:        "custom-roles-unsupported-permissions",
+		Description: "Custom roles including permissions that custom roles don't support",
+		Query: `SELECT r.id AS role_id, r.parent, rp.permission_id, p.stage
+FROM role r
+         JOIN role_permission rp ON rp.role_id = r.id
+         JOIN permission p ON p.name = rp.permission_id
+WHERE r.parent <> ''
+  AND p.custom_roles_support_level = 'NOT_SUPPORTED'
+ORDER BY r.id, rp.permission_id`,
+	},
+	{
+		Name:        "unresolved-roles",
+		Description: "Roles granted by bindings but missing from the role table",
+		Query: `SELECT rrp.role_id, COUNT(*) AS bindings, COUNT(DISTINCT rrp.resource_id) AS resources
+FROM resource_role_principal rrp
+         LEFT JOIN role r ON r.id = rrp.role_id AND r.tenant_id IN (rrp.tenant_id, '')
+WHERE r.id IS NULL
+GROUP BY rrp.role_id
+ORDER BY rrp.role_id`,
+	},
+}
+
+// WriteReport runs the report called name and writes its rows to w as CSV, preceded by a header row.
+func WriteReport(storage Storage, name string, w io.Writer) error {
+	for _, report := range Reports {
+		if report.Name == name {
+			return writeQueryCSV(storage.DB(), report.Query, w, true)
+		}
+	}
+	return fmt.Errorf("unknown report %q", name)
+}
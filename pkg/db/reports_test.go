@@ -0,0 +1,54 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+)
+
+func TestRoleReportsTenants(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	insertTenant(t, s, "a")
+	insertTenant(t, s, "b")
+	// Both tenants define the custom role; the predefined role is shared.
+	if _, err := s.db.Exec("UPDATE role SET stage = 'DEPRECATED' WHERE id = 'organizations/1/roles/custom'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InsertRoles(ctx, []model.Role{{ID: "roles/old", Title: "Old", Stage: "DEPRECATED"}}); err != nil {
+		t.Fatal(err)
+	}
+	err := s.InsertResourceIAMPermission(ctx, []model.ResourceIAMPermission{
+		{ResourceID: "//cloudresourcemanager.googleapis.com/projects/2", PrincipalID: "user:shared@example.com", RoleID: "roles/old", AssetType: "cloudresourcemanager.googleapis.com/Project", HierarchyID: "projects/2", TenantID: "a"},
+		{ResourceID: "//cloudresourcemanager.googleapis.com/projects/2", PrincipalID: "user:shared@example.com", RoleID: "roles/missing", AssetType: "cloudresourcemanager.googleapis.com/Project", HierarchyID: "projects/2", TenantID: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		report string
+		want   []string
+	}{
+		// One row per binding of a deprecated role: a binding of the custom role in each tenant and the predefined one.
+		{"deprecated-roles", []string{
+			"user:shared@example.com,organizations/1/roles/custom,DEPRECATED,false,//cloudresourcemanager.googleapis.com/projects/2,projects/2,a",
+			"user:shared@example.com,organizations/1/roles/custom,DEPRECATED,false,//cloudresourcemanager.googleapis.com/projects/2,projects/2,b",
+			"user:shared@example.com,roles/old,DEPRECATED,false,//cloudresourcemanager.googleapis.com/projects/2,projects/2,a",
+		}},
+		{"unresolved-roles", []string{"roles/missing,1,1"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteReport(s, tt.report, &buf); err != nil {
+			t.Fatal(err)
+		}
+		rows := strings.Split(strings.TrimSpace(buf.String()), "\n")[1:]
+		if strings.Join(rows, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s report rows:\n%s\nwant:\n%s", tt.report, strings.Join(rows, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
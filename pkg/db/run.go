@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Statuses of a dump run.
+const (
+	RunRunning   = "running"
+	RunFailed    = "failed"
+	RunCompleted = "completed"
+)
+
+// ErrRunNotFound is returned when looking up a run that is not in the database.
+var ErrRunNotFound = errors.New("run not found")
+
+// Run is one execution of the dump command. Parameters records what the run collects, so that a resumed run
+// can't mix the data of different organizations.
+type Run struct {
+	ID         string
+	Parameters string
+	Status     string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// Checkpoint is the progress of one step of a run. PageToken is the token of the next page to collect,
+// empty until the step has written its first page.
+type Checkpoint struct {
+	RunID     string
+	Step      string
+	PageToken string
+	Completed bool
+	UpdatedAt time.Time
+}
+
+func (s *store) StartRun(ctx context.Context, run Run) error {
+	query := fmt.Sprintf("INSERT INTO dump_run (id, parameters, status, started_at) VALUES (%s)", s.placeholders(4))
+	_, err := s.db.ExecContext(ctx, query, run.ID, run.Parameters, run.Status, run.StartedAt.UTC())
+	return err
+}
+
+// SetRunStatus updates the status of a run, recording when it finished unless it is running again.
+func (s *store) SetRunStatus(ctx context.Context, id, status string) error {
+	var finishedAt *time.Time
+	if status != RunRunning {
+		now := time.Now().UTC()
+		finishedAt = &now
+	}
+	query := fmt.Sprintf("UPDATE dump_run SET status = %s, finished_at = %s WHERE id = %s",
+		s.dialect.placeholder(1), s.dialect.placeholder(2), s.dialect.placeholder(3))
+	_, err := s.db.ExecContext(ctx, query, status, finishedAt, id)
+	return err
+}
+
+func (s *store) GetRun(ctx context.Context, id string) (Run, error) {
+	return s.queryRun(ctx, "SELECT id, parameters, status, started_at, finished_at FROM dump_run WHERE id = "+s.dialect.placeholder(1), id)
+}
+
+// LatestRun returns the run started last, whose data is the one currently in the database.
+func (s *store) LatestRun(ctx context.Context) (Run, error) {
+	return s.queryRun(ctx, "SELECT id, parameters, status, started_at, finished_at FROM dump_run ORDER BY started_at DESC LIMIT 1")
+}
+
+func (s *store) queryRun(ctx context.Context, query string, args ...any) (Run, error) {
+	var run Run
+	var finishedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&run.ID, &run.Parameters, &run.Status, &run.StartedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Run{}, ErrRunNotFound
+	}
+	if err != nil {
+		return Run{}, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return run, nil
+}
+
+// SaveCheckpoint records the progress of a step, replacing its previous checkpoint.
+func (s *store) SaveCheckpoint(ctx context.Context, checkpoint Checkpoint) error {
+	query := fmt.Sprintf(`INSERT INTO dump_checkpoint (run_id, step, page_token, completed, updated_at) VALUES (%s)
+ON CONFLICT (run_id, step) DO UPDATE SET page_token = excluded.page_token, completed = excluded.completed, updated_at = excluded.updated_at`,
+		s.placeholders(5))
+	_, err := s.db.ExecContext(ctx, query, checkpoint.RunID, checkpoint.Step, checkpoint.PageToken, checkpoint.Completed, time.Now().UTC())
+	return err
+}
+
+// Checkpoints returns the last checkpoint of every step of a run that saved one.
+func (s *store) Checkpoints(ctx context.Context, runID string) ([]Checkpoint, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT run_id, step, page_token, completed, updated_at FROM dump_checkpoint WHERE run_id = "+s.dialect.placeholder(1), runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checkpoints []Checkpoint
+	for rows.Next() {
+		var c Checkpoint
+		if err := rows.Scan(&c.RunID, &c.Step, &c.PageToken, &c.Completed, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, rows.Err()
+}
+
+// placeholders returns the comma separated bind parameters of a query taking n arguments.
+func (s *store) placeholders(n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = s.dialect.placeholder(i + 1)
+	}
+	return strings.Join(p, ", ")
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// sqliteMaxVariables is the maximum number of bind parameters in a single SQLite statement.
+const sqliteMaxVariables = 32766
+
+type sqliteDialect struct {
+	batchSize int
+}
+
+func openSQLite(path string, opts Options) (*store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer at a time, and pragmas only apply to the connection they run on:
+	// sharing one connection avoids lock contention and keeps the settings below in effect.
+	db.SetMaxOpenConns(1)
+
+	// In WAL mode, NORMAL synchronization cannot corrupt the database, it only risks losing the last
+	// transactions on power loss, which a dump can simply be run again for.
+	pragmas := []string{
+		"PRAGMA synchronous = NORMAL;",
+		"PRAGMA journal_mode = WAL;",
+		"PRAGMA cache_size = -80000;",
+		"PRAGMA temp_store = MEMORY;",
+	}
+
+	for _, pragma := range pragmas {
+		_, err = db.Exec(pragma)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to execute %s: %v", pragma, err)
+		}
+	}
+
+	return &store{db: db, dialect: sqliteDialect{batchSize: opts.batchSize()}}, nil
+}
+
+func (sqliteDialect) name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+// insertRows writes rows in a single transaction, using multi-row INSERT statements of up to batchSize rows.
+func (d sqliteDialect) insertRows(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]any, ignoreConflicts bool) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	verb := "INSERT"
+	if ignoreConflicts {
+		verb = "INSERT OR IGNORE"
+	}
+	batchSize := min(d.batchSize, sqliteMaxVariables/len(columns))
+	rowPlaceholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	queryPrefix := fmt.Sprintf("%s INTO %s (%s) VALUES ", verb, table, strings.Join(columns, ", "))
+	valuesFor := func(n int) string {
+		return strings.TrimSuffix(strings.Repeat(rowPlaceholders+", ", n), ", ")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Full batches all share the same statement, which is prepared once.
+	var fullBatchStmt *sql.Stmt
+	args := make([]any, 0, batchSize*len(columns))
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+		args = args[:0]
+		for _, row := range batch {
+			args = append(args, row...)
+		}
+
+		if len(batch) == batchSize {
+			if fullBatchStmt == nil {
+				if fullBatchStmt, err = tx.PrepareContext(ctx, queryPrefix+valuesFor(len(batch))); err != nil {
+					return err
+				}
+				defer fullBatchStmt.Close()
+			}
+			_, err = fullBatchStmt.ExecContext(ctx, args...)
+		} else {
+			_, err = tx.ExecContext(ctx, queryPrefix+valuesFor(len(batch)), args...)
+		}
+		if err != nil {
+			return fmt.Errorf("inserting rows %d to %d into %s: %v", start, start+len(batch)-1, table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (sqliteDialect) listTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	return queryStrings(ctx, db, `SELECT name FROM sqlite_master WHERE type='table' ORDER BY name;`)
+}
+
+func (sqliteDialect) listColumns(ctx context.Context, db *sql.DB, tableName string) ([]Column, error) {
+	return queryColumns(ctx, db, `SELECT name, type FROM pragma_table_info(?) ORDER BY cid;`, tableName)
+}
@@ -1,101 +1,346 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"slices"
 
 	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
 )
 
-func InsertHierarchies(db *sql.DB, hierarchies []model.Hierarchy) error {
+// Storage persists the dumped IAM data. Implementations exist for SQLite and PostgreSQL.
+type Storage interface {
+	InsertHierarchies(ctx context.Context, hierarchies []model.Hierarchy) error
+	InsertPrincipals(ctx context.Context, principals []model.Principal) error
+	InsertPrincipalRelationships(ctx context.Context, relationships []model.PrincipalRelationship) error
+	InsertResourceIAMPermission(ctx context.Context, permissions []model.ResourceIAMPermission) error
+	InsertRoles(ctx context.Context, roles []model.Role) error
+	InsertResources(ctx context.Context, resources []model.Resource) error
+	InsertResourceAncestors(ctx context.Context, ancestors []model.ResourceAncestor) error
+	InsertTags(ctx context.Context, keys []model.TagKey, values []model.TagValue) error
+	InsertPermissions(ctx context.Context, permissions []model.Permission) error
+
+	// Migrate brings the schema up to date by applying pending migrations.
+	Migrate(ctx context.Context) error
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	ClearData(ctx context.Context, keep ...string) error
+	// ClearTenantData deletes the rows of a single tenant, see its implementation.
+	ClearTenantData(ctx context.Context, tenantID string) error
+
+	// ListResourceIDs returns the resources holding bindings, and DeleteResourceIAMPermissions removes their bindings.
+	ListResourceIDs(ctx context.Context) ([]string, error)
+	DeleteResourceIAMPermissions(ctx context.Context, resourceIDs []string) error
+	// UnresolvedRoles returns the roles granted by bindings but not collected, with the tenant of their bindings.
+	UnresolvedRoles(ctx context.Context) (map[string]string, error)
+	// ResolveHierarchy computes what derives from the whole hierarchy once it is collected, see its implementation.
+	ResolveHierarchy(ctx context.Context) error
+	// HierarchyTree returns the hierarchy with the number of bindings attributed to each node.
+	HierarchyTree(ctx context.Context, filter TreeFilter) ([]HierarchyNode, error)
+	// AssessConditions stores, for the bindings whose condition checks tags, the result of evaluate given the
+	// effective tags of their resource.
+	AssessConditions(ctx context.Context, evaluate func(expression string, tags []model.ResourceTag) string) error
+
+	// StartRun records a new dump run, GetRun and LatestRun look runs up and SetRunStatus updates them.
+	StartRun(ctx context.Context, run Run) error
+	GetRun(ctx context.Context, id string) (Run, error)
+	LatestRun(ctx context.Context) (Run, error)
+	SetRunStatus(ctx context.Context, id, status string) error
+	SaveCheckpoint(ctx context.Context, checkpoint Checkpoint) error
+	Checkpoints(ctx context.Context, runID string) ([]Checkpoint, error)
+
+	// ListTables returns the name of every data table in the database.
+	ListTables(ctx context.Context) ([]string, error)
+	// ListColumns returns the columns of a table in declaration order.
+	ListColumns(ctx context.Context, tableName string) ([]Column, error)
+
+	DB() *sql.DB
+	Close() error
+}
+
+// Column describes a table column as declared in the schema.
+type Column struct {
+	Name string
+	Type string
+}
+
+// BindingsTable is the table holding the IAM bindings of every resource.
+const BindingsTable = "resource_role_principal"
+
+// AncestorsTable is the table holding the ancestors of every collected resource and of every resource holding bindings.
+const AncestorsTable = "resource_ancestor"
+
+// dataTables lists the tables filled by a dump.
+var dataTables = []string{"hierarchy", "permission", "principal", "principal_hierarchy", "principal_tenant", "resource", "resource_ancestor", "resource_role_principal", "role", "role_permission", "tag_binding", "tag_key", "tag_value"}
+
+// tenantTables lists the data tables whose rows belong to a tenant.
+var tenantTables = []string{"hierarchy", "principal_hierarchy", "principal_tenant", "resource", "resource_ancestor", "resource_role_principal", "role", "tag_binding", "tag_key", "tag_value"}
+
+// internalTables lists the tables holding the bookkeeping of the tool rather than dumped data.
+var internalTables = []string{"schema_version", "dump_run", "dump_checkpoint"}
+
+// dialect holds what differs between database engines.
+type dialect interface {
+	// name is the directory holding the dialect migrations.
+	name() string
+	// placeholder returns the bind parameter for the nth (1-based) argument of a query.
+	placeholder(n int) string
+	// insertRows writes rows into table. Rows whose primary or unique key already exists are skipped
+	// if ignoreConflicts is set, and fail the insert otherwise.
+	insertRows(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]any, ignoreConflicts bool) error
+	listTables(ctx context.Context, db *sql.DB) ([]string, error)
+	listColumns(ctx context.Context, db *sql.DB, tableName string) ([]Column, error)
+}
+
+// store maps the model to table rows and leaves writing them to its dialect. Rows that already exist are skipped,
+// so that a resumed dump can write again what it collected after its last checkpoint.
+type store struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (s *store) InsertHierarchies(ctx context.Context, hierarchies []model.Hierarchy) error {
+	rows := make([][]any, 0, len(hierarchies))
 	for _, hierarchy := range hierarchies {
-		_, err := db.Exec(`INSERT INTO hierarchy (id, name, type, parent_id) VALUES (?, ?, ?, ?)`, hierarchy.ID, hierarchy.Name, hierarchy.Type, hierarchy.ParentID)
-		if err != nil {
-			return fmt.Errorf("error inserting hierarchy %v: %v", hierarchy, err)
-		}
+		rows = append(rows, []any{hierarchy.ID, hierarchy.Name, hierarchy.Type, hierarchy.ParentID, hierarchy.TenantID})
+	}
+	if err := s.dialect.insertRows(ctx, s.db, "hierarchy", []string{"id", "name", "type", "parent_id", "tenant_id"}, rows, true); err != nil {
+		return fmt.Errorf("error inserting hierarchies: %v", err)
 	}
 	return nil
 }
-func InsertPrincipals(db *sql.DB, principals []model.Principal) error {
-	stmt, err := db.Prepare("INSERT OR IGNORE INTO principal (id, name, type) VALUES (?, ?, ?)")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
 
+// InsertPrincipals writes principals once whatever the number of tenants they belong to, and records each of their
+// tenants in principal_tenant.
+func (s *store) InsertPrincipals(ctx context.Context, principals []model.Principal) error {
+	rows := make([][]any, 0, len(principals))
+	var tenantRows [][]any
 	for _, p := range principals {
-		_, err := stmt.Exec(p.ID, p.Name, p.Type)
-		if err != nil {
-			return err
+		rows = append(rows, []any{p.ID, p.Name, p.Type})
+		if p.TenantID != "" {
+			tenantRows = append(tenantRows, []any{p.ID, p.TenantID})
 		}
 	}
-	return nil
-}
-
-func InsertPrincipalRelationships(db *sql.DB, relationships []model.PrincipalRelationship) error {
-	stmt, err := db.Prepare("INSERT INTO principal_hierarchy (parent_id, child_id) VALUES (?, ?)")
-	if err != nil {
+	if err := s.dialect.insertRows(ctx, s.db, "principal", []string{"id", "name", "type"}, rows, true); err != nil {
 		return err
 	}
-	defer stmt.Close()
+	return s.dialect.insertRows(ctx, s.db, "principal_tenant", []string{"principal_id", "tenant_id"}, tenantRows, true)
+}
 
+func (s *store) InsertPrincipalRelationships(ctx context.Context, relationships []model.PrincipalRelationship) error {
+	rows := make([][]any, 0, len(relationships))
 	for _, r := range relationships {
-		_, err := stmt.Exec(r.ParentID, r.ChildID)
-		if err != nil {
-			return err
-		}
+		rows = append(rows, []any{r.ParentID, r.ChildID, r.TenantID})
 	}
-	return nil
+	return s.dialect.insertRows(ctx, s.db, "principal_hierarchy", []string{"parent_id", "child_id", "tenant_id"}, rows, true)
 }
 
-func InsertResourceIAMPermission(db *sql.DB, permissions []model.ResourceIAMPermission) error {
-	stmt, err := db.Prepare("INSERT INTO resource_role_principal (resource_id, principal_name, role_id, conditional, asset_type, hierarchy_id) VALUES (?, ?, ?, ?, ?, ?)")
-	if err != nil {
+func (s *store) InsertResourceIAMPermission(ctx context.Context, permissions []model.ResourceIAMPermission) error {
+	rows := make([][]any, 0, len(permissions))
+	for _, permission := range permissions {
+		rows = append(rows, []any{permission.ResourceID, permission.PrincipalID, permission.RoleID, permission.Conditional, permission.ConditionExpression, permission.AssetType, permission.HierarchyID, permission.TenantID})
+	}
+	columns := []string{"resource_id", "principal_name", "role_id", "conditional", "condition_expression", "asset_type", "hierarchy_id", "tenant_id"}
+	return s.dialect.insertRows(ctx, s.db, BindingsTable, columns, rows, true)
+}
+
+func (s *store) InsertRoles(ctx context.Context, roles []model.Role) error {
+	roleRows := make([][]any, 0, len(roles))
+	var permissionRows [][]any
+	for _, r := range roles {
+		for _, permission := range r.Permissions {
+			permissionRows = append(permissionRows, []any{r.ID, permission})
+		}
+		roleRows = append(roleRows, []any{r.ID, r.Title, r.Description, r.Stage, r.Deleted, r.Etag, r.Parent, r.TenantID})
+	}
+	roleColumns := []string{"id", "title", "description", "stage", "deleted", "etag", "parent", "tenant_id"}
+	if err := s.dialect.insertRows(ctx, s.db, "role", roleColumns, roleRows, true); err != nil {
 		return err
 	}
-	defer stmt.Close()
+	return s.dialect.insertRows(ctx, s.db, "role_permission", []string{"role_id", "permission_id"}, permissionRows, true)
+}
 
-	for _, permission := range permissions {
-		_, err := stmt.Exec(permission.ResourceID, permission.PrincipalID, permission.RoleID, permission.Conditional, permission.AssetType, permission.HierarchyID)
-		if err != nil {
-			return err
+func (s *store) InsertPermissions(ctx context.Context, permissions []model.Permission) error {
+	rows := make([][]any, 0, len(permissions))
+	for _, p := range permissions {
+		rows = append(rows, []any{p.Name, p.Title, p.Description, p.Service, p.Stage, p.CustomRolesSupportLevel, p.APIDisabled})
+	}
+	columns := []string{"name", "title", "description", "service", "stage", "custom_roles_support_level", "api_disabled"}
+	return s.dialect.insertRows(ctx, s.db, "permission", columns, rows, true)
+}
+
+// InsertResources writes the resources along with the tags attached to them and their ancestors.
+func (s *store) InsertResources(ctx context.Context, resources []model.Resource) error {
+	rows := make([][]any, 0, len(resources))
+	var tagRows, ancestorRows [][]any
+	for _, r := range resources {
+		for _, tag := range r.Tags {
+			tagRows = append(tagRows, []any{r.Name, tag.Key, tag.Value, tag.ValueID, r.TenantID})
+		}
+		for _, ancestor := range r.Ancestors {
+			ancestorRows = append(ancestorRows, []any{r.Name, ancestor, r.TenantID})
 		}
+		var labels, createTime any
+		if len(r.Labels) > 0 {
+			encoded, err := json.Marshal(r.Labels)
+			if err != nil {
+				return err
+			}
+			labels = string(encoded)
+		}
+		if !r.CreateTime.IsZero() {
+			createTime = r.CreateTime.UTC()
+		}
+		rows = append(rows, []any{r.Name, r.AssetType, r.Project, r.Location, labels, r.DisplayName, r.State, createTime, r.HierarchyID, r.TenantID})
 	}
-	return nil
+	columns := []string{"name", "asset_type", "project", "location", "labels", "display_name", "state", "create_time", "hierarchy_id", "tenant_id"}
+	if err := s.dialect.insertRows(ctx, s.db, "resource", columns, rows, true); err != nil {
+		return err
+	}
+	if err := s.dialect.insertRows(ctx, s.db, "tag_binding", []string{"resource_name", "tag_key", "tag_value", "tag_value_id", "tenant_id"}, tagRows, true); err != nil {
+		return err
+	}
+	return s.dialect.insertRows(ctx, s.db, AncestorsTable, []string{"resource_id", "ancestor_id", "tenant_id"}, ancestorRows, true)
 }
 
-func InsertRoles(db *sql.DB, roles []model.Role) error {
-	tx, err := db.Begin()
-	if err != nil {
+func (s *store) InsertTags(ctx context.Context, keys []model.TagKey, values []model.TagValue) error {
+	keyRows := make([][]any, 0, len(keys))
+	for _, k := range keys {
+		keyRows = append(keyRows, []any{k.ID, k.Parent, k.ShortName, k.NamespacedName, k.Description, k.TenantID})
+	}
+	if err := s.dialect.insertRows(ctx, s.db, "tag_key", []string{"id", "parent", "short_name", "namespaced_name", "description", "tenant_id"}, keyRows, true); err != nil {
 		return err
 	}
+	valueRows := make([][]any, 0, len(values))
+	for _, v := range values {
+		valueRows = append(valueRows, []any{v.ID, v.KeyID, v.ShortName, v.NamespacedName, v.Description, v.TenantID})
+	}
+	return s.dialect.insertRows(ctx, s.db, "tag_value", []string{"id", "tag_key_id", "short_name", "namespaced_name", "description", "tenant_id"}, valueRows, true)
+}
+
+func (s *store) InsertResourceAncestors(ctx context.Context, ancestors []model.ResourceAncestor) error {
+	rows := make([][]any, 0, len(ancestors))
+	for _, a := range ancestors {
+		rows = append(rows, []any{a.ResourceID, a.AncestorID, a.TenantID})
+	}
+	return s.dialect.insertRows(ctx, s.db, AncestorsTable, []string{"resource_id", "ancestor_id", "tenant_id"}, rows, true)
+}
 
-	roleStmt, err := tx.Prepare("INSERT OR IGNORE INTO role (id, title) VALUES (?, ?)")
+// UnresolvedRoles returns the roles granted by bindings but missing from role, each with the tenant of its bindings.
+func (s *store) UnresolvedRoles(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT b.role_id, MIN(b.tenant_id)
+FROM `+BindingsTable+` b
+         LEFT JOIN role r ON r.id = b.role_id AND r.tenant_id IN (b.tenant_id, '')
+WHERE r.id IS NULL
+GROUP BY b.role_id`)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	permissionStmt, err := tx.Prepare("INSERT OR IGNORE INTO role_permission (role_id, permission_id) VALUES (?, ?)")
+	defer rows.Close()
+
+	roles := map[string]string{}
+	for rows.Next() {
+		var id, tenantID string
+		if err := rows.Scan(&id, &tenantID); err != nil {
+			return nil, err
+		}
+		roles[id] = tenantID
+	}
+	return roles, rows.Err()
+}
+
+func (s *store) ListResourceIDs(ctx context.Context) ([]string, error) {
+	return queryStrings(ctx, s.db, "SELECT DISTINCT resource_id FROM "+BindingsTable)
+}
+
+// DeleteResourceIAMPermissions deletes the bindings of the given resources in a single transaction. Their ancestors are
+// left: the resources may still exist without an IAM policy.
+func (s *store) DeleteResourceIAMPermissions(ctx context.Context, resourceIDs []string) error {
+	if len(resourceIDs) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer roleStmt.Close()
+	defer tx.Rollback()
 
-	for _, r := range roles {
-		for _, permission := range r.Permissions {
-			_, err := permissionStmt.Exec(r.ID, permission)
-			if err != nil {
-				return err
-			}
+	const batchSize = 500
+	for start := 0; start < len(resourceIDs); start += batchSize {
+		batch := resourceIDs[start:min(start+batchSize, len(resourceIDs))]
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
 		}
-		_, err := roleStmt.Exec(r.ID, r.Title)
-		if err != nil {
-			return err
+		query := fmt.Sprintf("DELETE FROM %s WHERE resource_id IN (%s)", BindingsTable, s.placeholders(len(batch)))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("error deleting from %s: %v", BindingsTable, err)
 		}
+	}
+	return tx.Commit()
+}
 
+func (s *store) ListTables(ctx context.Context) ([]string, error) {
+	tables, err := s.dialect.listTables(ctx, s.db)
+	if err != nil {
+		return nil, err
 	}
-	err = tx.Commit()
+	var dataTables []string
+	for _, table := range tables {
+		if !slices.Contains(internalTables, table) {
+			dataTables = append(dataTables, table)
+		}
+	}
+	return dataTables, nil
+}
+
+func (s *store) ListColumns(ctx context.Context, tableName string) ([]Column, error) {
+	return s.dialect.listColumns(ctx, s.db, tableName)
+}
+
+func (s *store) DB() *sql.DB {
+	return s.db
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// queryStrings runs a query returning a single text column.
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// queryColumns runs a query returning column names and types.
+func queryColumns(ctx context.Context, db *sql.DB, query string, args ...any) ([]Column, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
 }
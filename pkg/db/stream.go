@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"golang.org/x/sync/errgroup"
+)
+
+// StreamPages runs fetch and the insertion of the pages it sends concurrently, so that records are written while
+// they are still being collected. Once the records of a page are inserted, checkpoint is called with the token of
+// the next page, from which an interrupted stream can be resumed. fetch must close the channel it is given once done.
+// The first error of either side cancels the other.
+func StreamPages[T any](ctx context.Context, fetch func(context.Context, chan<- model.Page[T]) error, insert func(context.Context, []T) error, checkpoint func(ctx context.Context, nextPageToken string) error) error {
+	// A slow insert blocks fetch once a page is waiting, which bounds the number of records held in memory.
+	pages := make(chan model.Page[T], 1)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		if err := fetch(ctx, pages); err != nil {
+			return fmt.Errorf("fetching: %v", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		for {
+			select {
+			case page, ok := <-pages:
+				if !ok {
+					return nil
+				}
+				if err := insert(ctx, page.Items); err != nil {
+					return fmt.Errorf("inserting: %v", err)
+				}
+				if err := checkpoint(ctx, page.NextPageToken); err != nil {
+					return fmt.Errorf("saving checkpoint: %v", err)
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+	return g.Wait()
+}
@@ -0,0 +1,121 @@
+package gcp
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Names of the APIs called by the collectors, as accepted in ClientOptions.RateLimits.
+const (
+	AssetAPI         = "asset"
+	IAMAPI           = "iam"
+	CloudIdentityAPI = "cloudidentity"
+	DirectoryAPI     = "directory"
+	// ResourceManagerAPI resolves the ancestors of folder and project scopes.
+	ResourceManagerAPI = "cloudresourcemanager"
+)
+
+// DefaultMembershipWorkers is the number of concurrent membership listings when ClientOptions.MembershipWorkers is not set.
+const DefaultMembershipWorkers = 10
+
+type ClientOptions struct {
+	// QuotaProjectID is the quota project of the Cloud Identity and Directory APIs.
+	QuotaProjectID string
+	// RateLimits is the maximum number of requests per second sent to each API, keyed by API name.
+	// APIs without a positive limit are not rate limited.
+	RateLimits map[string]float64
+	// Retry is the policy applied to every call of every API. The zero value selects DefaultRetryPolicy.
+	Retry RetryPolicy
+	// MembershipWorkers is the number of groups whose members are listed concurrently.
+	MembershipWorkers int
+	// ReadTime, if set, pins the Asset collectors to the state of assets at that time, within MaxReadTimeAge.
+	ReadTime time.Time
+}
+
+// APIStats counts the calls made to an API during the lifetime of the clients.
+type APIStats struct {
+	API   string
+	Calls []CallStats
+}
+
+// CallStats counts the calls made to one method of an API. A call is made of one attempt, plus one per retry.
+type CallStats struct {
+	Method   string
+	Calls    int64
+	Attempts int64
+	// Failures is the number of calls that failed once retries were exhausted or the error was not retryable.
+	Failures int64
+}
+
+// api rate limits and retries the calls made to one API, and counts them.
+type api struct {
+	name    string
+	limiter *rate.Limiter
+	retry   RetryPolicy
+
+	mu    sync.Mutex
+	calls map[string]*CallStats
+}
+
+func newAPI(name string, opts ClientOptions) *api {
+	a := &api{name: name, retry: opts.Retry.withDefaults(), calls: map[string]*CallStats{}}
+	if rps := opts.RateLimits[name]; rps > 0 {
+		// The token bucket holds one second worth of requests, so that idle periods don't allow large bursts.
+		a.limiter = rate.NewLimiter(rate.Limit(rps), max(int(rps), 1))
+	}
+	return a
+}
+
+// do calls f once the rate limiter allows it, retrying it according to the retry policy of the API.
+// method names the call in the stats.
+func (a *api) do(ctx context.Context, method string, f func() error) error {
+	attempts, err := a.retry.do(ctx, func() error {
+		if a.limiter != nil {
+			if err := a.limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		return f()
+	})
+	a.record(method, attempts, err)
+	return err
+}
+
+// unaryInterceptor applies do to every call made by a gRPC client.
+func (a *api) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return a.do(ctx, method, func() error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+func (a *api) record(method string, attempts int, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.calls[method]
+	if !ok {
+		s = &CallStats{Method: method}
+		a.calls[method] = s
+	}
+	s.Calls++
+	s.Attempts += int64(attempts)
+	if err != nil {
+		s.Failures++
+	}
+}
+
+func (a *api) stats() APIStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := APIStats{API: a.name}
+	for _, s := range a.calls {
+		stats.Calls = append(stats.Calls, *s)
+	}
+	sort.Slice(stats.Calls, func(i, j int) bool { return stats.Calls[i].Method < stats.Calls[j].Method })
+	return stats
+}
@@ -1,74 +1,83 @@
 package gcp
 
 import (
-	asset "cloud.google.com/go/asset/apiv1"
 	"cloud.google.com/go/asset/apiv1/assetpb"
+	"cloud.google.com/go/iam/apiv1/iampb"
 	"context"
 	"fmt"
 	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
 	"google.golang.org/api/iterator"
-	"google.golang.org/protobuf/types/known/structpb"
-	"log"
+	"slices"
 	"strings"
 )
 
-func FetchServiceAccounts(ctx context.Context, scope string) ([]model.Principal, error) {
-	client, err := asset.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("asset.NewClient: %v", err)
+// searchPageSize is the number of results per page of the Asset API searches, their maximum.
+const searchPageSize = 500
+
+// FetchServiceAccounts sends every service account in scope to out, one page at a time, starting from pageToken
+// (the first page if empty). It closes out when it returns.
+func (c *Clients) FetchServiceAccounts(ctx context.Context, scope, pageToken string, out chan<- model.Page[model.Principal]) error {
+	defer close(out)
+	if !c.readTime.IsZero() {
+		return c.listServiceAccounts(ctx, scope, pageToken, out)
 	}
-	defer client.Close()
 
 	req := &assetpb.SearchAllResourcesRequest{
 		Scope: scope, // e.g., "organizations/123456789"
 		AssetTypes: []string{
-			"iam.googleapis.com/ServiceAccount",
+			serviceAccountAssetType,
 		},
 	}
-	var serviceAccounts []model.Principal
 
-	it := client.SearchAllResources(ctx, req)
+	pager := iterator.NewPager(c.Asset.SearchAllResources(ctx, req), searchPageSize, pageToken)
 	for {
-		serviceAccount, err := it.Next()
-
-		if err == iterator.Done {
-			break
-		}
+		var serviceAccounts []*assetpb.ResourceSearchResult
+		nextPageToken, err := pager.NextPage(&serviceAccounts)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		segments := strings.Split(serviceAccount.Name, "/")
-		serviceAccountEmail := segments[len(segments)-1]
+		page := model.Page[model.Principal]{NextPageToken: nextPageToken}
+		for _, serviceAccount := range serviceAccounts {
+			segments := strings.Split(serviceAccount.Name, "/")
+			serviceAccountEmail := segments[len(segments)-1]
 
-		serviceAccounts = append(serviceAccounts, model.Principal{
-			ID:   serviceAccountEmail,
-			Name: serviceAccountEmail,
-			Type: "serviceAccount",
-		})
+			page.Items = append(page.Items, model.Principal{
+				ID:   serviceAccountEmail,
+				Name: serviceAccountEmail,
+				Type: "serviceAccount",
+			})
+		}
+		select {
+		case out <- page:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if nextPageToken == "" {
+			return nil
+		}
 	}
+}
 
-	return serviceAccounts, nil
+// hierarchyAssetTypes are the asset types making up the resource hierarchy.
+var hierarchyAssetTypes = []string{
+	"cloudresourcemanager.googleapis.com/Folder",
+	"cloudresourcemanager.googleapis.com/Project",
+	"cloudresourcemanager.googleapis.com/Organization",
 }
 
-func FetchHierarchies(ctx context.Context, scope string) ([]model.Hierarchy, error) {
-	client, err := asset.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("asset.NewClient: %v", err)
+func (c *Clients) FetchHierarchies(ctx context.Context, scope string) ([]model.Hierarchy, error) {
+	if !c.readTime.IsZero() {
+		return c.listHierarchies(ctx, scope)
 	}
-	defer client.Close()
 
 	req := &assetpb.SearchAllResourcesRequest{
-		Scope: scope, // e.g., "organizations/123456789"
-		AssetTypes: []string{
-			"cloudresourcemanager.googleapis.com/Folder",
-			"cloudresourcemanager.googleapis.com/Project",
-			"cloudresourcemanager.googleapis.com/Organization",
-		},
+		Scope:      scope, // e.g., "organizations/123456789"
+		AssetTypes: hierarchyAssetTypes,
 	}
 	var hierarchies []model.Hierarchy
 
-	it := client.SearchAllResources(ctx, req)
+	it := c.Asset.SearchAllResources(ctx, req)
 	for {
 		hierarchy, err := it.Next()
 
@@ -76,7 +85,7 @@ func FetchHierarchies(ctx context.Context, scope string) ([]model.Hierarchy, err
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		var id, name string
@@ -102,82 +111,144 @@ func FetchHierarchies(ctx context.Context, scope string) ([]model.Hierarchy, err
 	return hierarchies, nil
 }
 
-func FetchAssetIAMPolicy(ctx context.Context, scope string) ([]model.ResourceIAMPermission, error) {
-	client, err := asset.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("asset.NewClient: %v", err)
+// IAMPolicy is the IAM policy of a resource, as one record per member of each binding, along with the projects,
+// folders and organization the resource belongs to, itself included if it is one.
+type IAMPolicy struct {
+	ResourceID string
+	Ancestors  []string
+	Bindings   []model.ResourceIAMPermission
+}
+
+// FetchAssetIAMPolicy sends every IAM policy in scope to out, one page at a time, starting from pageToken (the first
+// page if empty). It closes out when it returns.
+func (c *Clients) FetchAssetIAMPolicy(ctx context.Context, scope, pageToken string, out chan<- model.Page[IAMPolicy]) error {
+	defer close(out)
+	if !c.readTime.IsZero() {
+		return c.listIAMPolicies(ctx, scope, pageToken, out)
 	}
-	defer client.Close()
 
 	req := &assetpb.SearchAllIamPoliciesRequest{
 		Scope: scope, // e.g., "organizations/123456789"
 		Query: "memberTypes=(group OR user OR allUsers OR serviceAccount) OR memberTypes:deleted",
 	}
-	var resourcePolicies []model.ResourceIAMPermission
-	it := client.SearchAllIamPolicies(ctx, req)
+	pager := iterator.NewPager(c.Asset.SearchAllIamPolicies(ctx, req), searchPageSize, pageToken)
 	for {
-		policy, err := it.Next()
+		var policies []*assetpb.IamPolicySearchResult
+		nextPageToken, err := pager.NextPage(&policies)
+		if err != nil {
+			return err
+		}
 
-		if err == iterator.Done {
-			break
+		page := model.Page[IAMPolicy]{NextPageToken: nextPageToken}
+		for _, policy := range policies {
+			page.Items = append(page.Items, policyFromSearchResult(policy))
 		}
-		if err != nil {
-			log.Fatal(err)
+		select {
+		case out <- page:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		var hierarchyID string
-		if policy.Project != "" {
-			hierarchyID = policy.Project
-		} else if len(policy.Folders) == 0 {
-			hierarchyID = policy.Organization
-		} else {
-			hierarchyID = policy.Folders[0]
+		if nextPageToken == "" {
+			return nil
 		}
+	}
+}
+
+// policyFromSearchResult converts a searched IAM policy. Search results list the folders of a resource in no
+// particular order: bindings are attributed to the first one until the hierarchy is known, see
+// db.Storage.ResolveHierarchy.
+func policyFromSearchResult(policy *assetpb.IamPolicySearchResult) IAMPolicy {
+	return IAMPolicy{
+		ResourceID: policy.Resource,
+		Ancestors:  searchResultAncestors(policy.Resource, policy.Project, policy.Folders, policy.Organization),
+		Bindings:   bindingsFromPolicy(policy.Resource, policy.AssetType, searchResultHierarchyID(policy.Project, policy.Folders, policy.Organization), policy.Policy),
+	}
+}
 
-		for _, binding := range policy.Policy.Bindings {
-			condition := ""
-			if binding.Condition != nil {
-				condition = binding.Condition.Title
+// AncestorRecords returns one record per ancestor of the resource of the policy, tagged with tenantID.
+func (p IAMPolicy) AncestorRecords(tenantID string) []model.ResourceAncestor {
+	records := make([]model.ResourceAncestor, 0, len(p.Ancestors))
+	for _, ancestor := range p.Ancestors {
+		records = append(records, model.ResourceAncestor{ResourceID: p.ResourceID, AncestorID: ancestor, TenantID: tenantID})
+	}
+	return records
+}
+
+// bindingsFromPolicy returns one record per member of every binding of the IAM policy of a resource.
+// hierarchyID is the project, folder or organization closest to the resource.
+func bindingsFromPolicy(resource, assetType, hierarchyID string, policy *iampb.Policy) []model.ResourceIAMPermission {
+	var permissions []model.ResourceIAMPermission
+	for _, binding := range policy.GetBindings() {
+		condition, expression := "", ""
+		if binding.Condition != nil {
+			condition, expression = binding.Condition.Title, binding.Condition.Expression
+		}
+		for _, member := range binding.Members {
+			if strings.HasPrefix(member, "project") {
+				continue
 			}
-			for _, member := range binding.Members {
-				if strings.HasPrefix(member, "project") {
-					continue
-				}
-				principalEmail := member
-				parts := strings.SplitN(member, ":", 2)
-				if len(parts) == 2 {
-					principalEmail = parts[1]
-				}
-				resourcePolicies = append(resourcePolicies, model.ResourceIAMPermission{
-					ResourceID:  policy.Resource,
-					PrincipalID: principalEmail,
-					RoleID:      binding.Role,
-					Conditional: condition,
-					AssetType:   policy.AssetType,
-					HierarchyID: hierarchyID,
-				})
+			principalEmail := member
+			parts := strings.SplitN(member, ":", 2)
+			if len(parts) == 2 {
+				principalEmail = parts[1]
 			}
+			permissions = append(permissions, model.ResourceIAMPermission{
+				ResourceID:          resource,
+				PrincipalID:         principalEmail,
+				RoleID:              binding.Role,
+				Conditional:         condition,
+				ConditionExpression: expression,
+				AssetType:           assetType,
+				HierarchyID:         hierarchyID,
+			})
 		}
 	}
+	return permissions
+}
 
-	return resourcePolicies, nil
+// searchResultAncestors returns the projects, folders and organization a searched resource belongs to, itself included
+// if it is one, sorted.
+func searchResultAncestors(resource, project string, folders []string, organization string) []string {
+	var ancestors []string
+	if id, ok := strings.CutPrefix(resource, "//cloudresourcemanager.googleapis.com/"); ok {
+		ancestors = append(ancestors, id)
+	}
+	if project != "" {
+		ancestors = append(ancestors, project)
+	}
+	ancestors = append(ancestors, folders...)
+	if organization != "" {
+		ancestors = append(ancestors, organization)
+	}
+	slices.Sort(ancestors)
+	return slices.Compact(ancestors)
 }
 
-func fetchCustomRoles(ctx context.Context, scope string) ([]model.Role, error) {
-	client, err := asset.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("asset.NewClient: %v", err)
+// searchResultHierarchyID returns the project, folder or organization closest to the resource of a search result,
+// given the ones it belongs to.
+func searchResultHierarchyID(project string, folders []string, organization string) string {
+	if project != "" {
+		return project
+	} else if len(folders) == 0 {
+		return organization
+	}
+	return folders[0]
+}
+
+func (c *Clients) fetchCustomRoles(ctx context.Context, scope string) ([]model.Role, error) {
+	if !c.readTime.IsZero() {
+		return c.listCustomRoles(ctx, scope)
 	}
-	defer client.Close()
 
 	req := &assetpb.SearchAllResourcesRequest{
 		Scope: scope, // e.g., "organizations/123456789"
 		AssetTypes: []string{
-			"iam.googleapis.com/Role",
+			roleAssetType,
 		},
 	}
 	var customRoles []model.Role
 
-	it := client.SearchAllResources(ctx, req)
+	it := c.Asset.SearchAllResources(ctx, req)
 	for {
 		role, err := it.Next()
 
@@ -185,22 +256,15 @@ func fetchCustomRoles(ctx context.Context, scope string) ([]model.Role, error) {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
-		}
-		var permissions []string
-		if attr, exists := role.AdditionalAttributes.Fields["includedPermissions"]; exists {
-			if lv, ok := attr.GetKind().(*structpb.Value_ListValue); ok {
-				for _, v := range lv.ListValue.Values {
-					if perm, ok := v.GetKind().(*structpb.Value_StringValue); ok {
-						permissions = append(permissions, perm.StringValue)
-					}
-				}
-			}
+			return nil, err
 		}
+		id := strings.TrimPrefix(role.Name, "//iam.googleapis.com/")
 		customRoles = append(customRoles, model.Role{
-			ID:          strings.TrimPrefix(role.Name, "//iam.googleapis.com/"),
+			ID:          id,
 			Title:       role.DisplayName,
-			Permissions: permissions,
+			Description: role.Description,
+			Parent:      roleParent(id),
+			Permissions: stringList(role.AdditionalAttributes.GetFields()["includedPermissions"]),
 		})
 	}
 	return customRoles, nil
@@ -0,0 +1,148 @@
+package gcp
+
+import (
+	"cloud.google.com/go/bigquery"
+	"context"
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// snapshotDateColumn is the column added to every published table, BigQuery tables being partitioned on it.
+const snapshotDateColumn = "snapshot_date"
+
+type BigQueryOptions struct {
+	ProjectID    string
+	DatasetID    string
+	Location     string
+	SnapshotDate time.Time
+	// Endpoint overrides the BigQuery API endpoint, e.g. to target a local emulator. Requests are then unauthenticated.
+	Endpoint string
+}
+
+// PublishToBigQuery loads every table of the database into the dataset. Tables are created on first use with a
+// schema derived from the database schema, and each run appends its rows to the partition of opts.SnapshotDate.
+func PublishToBigQuery(ctx context.Context, storage db.Storage, opts BigQueryOptions) error {
+	var clientOpts []option.ClientOption
+	if opts.Endpoint != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint(opts.Endpoint), option.WithoutAuthentication())
+	}
+	client, err := bigquery.NewClient(ctx, opts.ProjectID, clientOpts...)
+	if err != nil {
+		return fmt.Errorf("bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+	if opts.Location != "" {
+		client.Location = opts.Location
+	}
+
+	dataset := client.Dataset(opts.DatasetID)
+	if err := dataset.Create(ctx, &bigquery.DatasetMetadata{Location: opts.Location}); err != nil && !isAlreadyExists(err) {
+		return fmt.Errorf("creating dataset %s: %v", opts.DatasetID, err)
+	}
+
+	tables, err := storage.ListTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		fmt.Printf("Publishing table: %s\n", table)
+		if err := publishTable(ctx, storage, dataset, table, opts.SnapshotDate); err != nil {
+			return fmt.Errorf("publishing table %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+func publishTable(ctx context.Context, storage db.Storage, dataset *bigquery.Dataset, tableName string, snapshotDate time.Time) error {
+	columns, err := storage.ListColumns(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	schema := bigQuerySchema(columns)
+
+	table := dataset.Table(tableName)
+	if err := table.Create(ctx, tableMetadata(schema)); err != nil && !isAlreadyExists(err) {
+		return err
+	}
+
+	// Stream the CSV export of the table into the load job rather than staging it on disk.
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(db.WriteTableCSV(storage.DB(), tableName, w, false, snapshotDate.Format("2006-01-02")))
+	}()
+	defer r.Close()
+
+	job, err := tableLoader(table, r, schema).Run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+	if stats, ok := status.Statistics.Details.(*bigquery.LoadStatistics); ok {
+		log.Printf("Loaded %d rows into %s", stats.OutputRows, tableName)
+	}
+	return nil
+}
+
+// tableMetadata describes a published table, partitioned by day on the snapshot date.
+func tableMetadata(schema bigquery.Schema) *bigquery.TableMetadata {
+	return &bigquery.TableMetadata{
+		Schema: schema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Type:  bigquery.DayPartitioningType,
+			Field: snapshotDateColumn,
+		},
+	}
+}
+
+// tableLoader configures the job appending the CSV rows read from r to table, adding the columns it lacks.
+func tableLoader(table *bigquery.Table, r io.Reader, schema bigquery.Schema) *bigquery.Loader {
+	source := bigquery.NewReaderSource(r)
+	source.SourceFormat = bigquery.CSV
+	source.Schema = schema
+	loader := table.LoaderFrom(source)
+	loader.WriteDisposition = bigquery.WriteAppend
+	loader.SchemaUpdateOptions = []string{"ALLOW_FIELD_ADDITION"}
+	return loader
+}
+
+// bigQuerySchema maps SQLite and PostgreSQL column declarations to BigQuery fields and appends the snapshot date.
+// Columns are nullable so that columns added to the SQLite schema later can be appended to existing tables.
+func bigQuerySchema(columns []db.Column) bigquery.Schema {
+	var schema bigquery.Schema
+	for _, c := range columns {
+		var fieldType bigquery.FieldType
+		columnType := strings.ToUpper(c.Type)
+		switch {
+		case columnType == "INTEGER", columnType == "INT", columnType == "BIGINT":
+			fieldType = bigquery.IntegerFieldType
+		case columnType == "REAL", columnType == "FLOAT", columnType == "DOUBLE", columnType == "DOUBLE PRECISION":
+			fieldType = bigquery.FloatFieldType
+		case columnType == "BOOLEAN":
+			fieldType = bigquery.BooleanFieldType
+		case strings.HasPrefix(columnType, "TIMESTAMP"), columnType == "DATETIME":
+			fieldType = bigquery.TimestampFieldType
+		default:
+			fieldType = bigquery.StringFieldType
+		}
+		schema = append(schema, &bigquery.FieldSchema{Name: c.Name, Type: fieldType})
+	}
+	return append(schema, &bigquery.FieldSchema{Name: snapshotDateColumn, Type: bigquery.DateFieldType, Required: true})
+}
+
+func isAlreadyExists(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == http.StatusConflict
+}
@@ -0,0 +1,103 @@
+package gcp
+
+import (
+	"cloud.google.com/go/bigquery"
+	"context"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/db"
+	"google.golang.org/api/option"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBigQuerySchema(t *testing.T) {
+	// Declarations as listed by SQLite, then by PostgreSQL.
+	columns := []db.Column{
+		{Name: "id", Type: "TEXT"},
+		{Name: "depth", Type: "INTEGER"},
+		{Name: "count", Type: "bigint"},
+		{Name: "ratio", Type: "REAL"},
+		{Name: "score", Type: "double precision"},
+		{Name: "deleted", Type: "BOOLEAN"},
+		{Name: "started_at", Type: "TIMESTAMP"},
+		{Name: "updated_at", Type: "timestamp with time zone"},
+		{Name: "created_at", Type: "DATETIME"},
+		{Name: "name", Type: "character varying"},
+		{Name: "untyped", Type: ""},
+	}
+	want := []bigquery.FieldType{
+		bigquery.StringFieldType,
+		bigquery.IntegerFieldType,
+		bigquery.IntegerFieldType,
+		bigquery.FloatFieldType,
+		bigquery.FloatFieldType,
+		bigquery.BooleanFieldType,
+		bigquery.TimestampFieldType,
+		bigquery.TimestampFieldType,
+		bigquery.TimestampFieldType,
+		bigquery.StringFieldType,
+		bigquery.StringFieldType,
+	}
+
+	schema := bigQuerySchema(columns)
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("schema has %d fields, want %d", len(schema), len(columns)+1)
+	}
+	for i, c := range columns {
+		field := schema[i]
+		if field.Name != c.Name || field.Type != want[i] {
+			t.Errorf("column %s %q maps to %s %s, want %s %s", c.Name, c.Type, field.Name, field.Type, c.Name, want[i])
+		}
+		if field.Required {
+			t.Errorf("field %s is required, columns added later could not be appended", field.Name)
+		}
+	}
+	last := schema[len(schema)-1]
+	if last.Name != snapshotDateColumn || last.Type != bigquery.DateFieldType || !last.Required {
+		t.Errorf("last field is %s %s required=%v, want the required %s DATE", last.Name, last.Type, last.Required, snapshotDateColumn)
+	}
+}
+
+func TestTableMetadata(t *testing.T) {
+	schema := bigQuerySchema([]db.Column{{Name: "id", Type: "TEXT"}})
+	metadata := tableMetadata(schema)
+	if p := metadata.TimePartitioning; p == nil || p.Type != bigquery.DayPartitioningType || p.Field != snapshotDateColumn {
+		t.Errorf("tables are partitioned by %+v, want by day on %s", p, snapshotDateColumn)
+	}
+	if len(metadata.Schema) != len(schema) {
+		t.Errorf("table schema has %d fields, want %d", len(metadata.Schema), len(schema))
+	}
+}
+
+func TestTableLoader(t *testing.T) {
+	// Nothing is sent: the client is only needed to build table handles.
+	client, err := bigquery.NewClient(context.Background(), "project", option.WithEndpoint("http://localhost:0"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	schema := bigQuerySchema([]db.Column{{Name: "id", Type: "TEXT"}})
+	table := client.Dataset("dataset").Table("role")
+	loader := tableLoader(table, strings.NewReader("roles/viewer,2024-03-01\n"), schema)
+
+	if loader.WriteDisposition != bigquery.WriteAppend {
+		t.Errorf("write disposition is %s, want %s so that snapshots accumulate", loader.WriteDisposition, bigquery.WriteAppend)
+	}
+	if !slices.Equal(loader.SchemaUpdateOptions, []string{"ALLOW_FIELD_ADDITION"}) {
+		t.Errorf("schema update options are %v, want ALLOW_FIELD_ADDITION", loader.SchemaUpdateOptions)
+	}
+	if loader.Dst != table {
+		t.Errorf("loader writes to %v, want %v", loader.Dst, table)
+	}
+	source, ok := loader.Src.(*bigquery.ReaderSource)
+	if !ok {
+		t.Fatalf("loader reads from a %T, want a reader source", loader.Src)
+	}
+	if source.SourceFormat != bigquery.CSV {
+		t.Errorf("source format is %s, want CSV", source.SourceFormat)
+	}
+	if len(source.Schema) != len(schema) {
+		t.Errorf("source schema has %d fields, want %d", len(source.Schema), len(schema))
+	}
+}
@@ -0,0 +1,93 @@
+package gcp
+
+import (
+	asset "cloud.google.com/go/asset/apiv1"
+	iamadmin "cloud.google.com/go/iam/admin/apiv1"
+	"context"
+	"fmt"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/cloudidentity/v1"
+	"google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"time"
+)
+
+// Clients holds the API clients shared by every collector of a dump. They are safe for concurrent use.
+type Clients struct {
+	Asset         *asset.Client
+	IAM           *iamadmin.IamClient
+	CloudIdentity *cloudidentity.Service
+	Directory     *admin.Service
+	// ResourceManager is only called to resolve the ancestors of the scopes of a dump.
+	ResourceManager *cloudresourcemanager.Service
+
+	assetAPI           *api
+	iamAPI             *api
+	cloudIdentityAPI   *api
+	directoryAPI       *api
+	resourceManagerAPI *api
+	membershipWorkers  int
+	readTime           time.Time
+}
+
+// NewClients creates the API clients. Calls to the Asset and IAM APIs are rate limited and retried transparently,
+// while the Cloud Identity, Directory and Resource Manager collectors go through their API explicitly.
+func NewClients(ctx context.Context, opts ClientOptions) (*Clients, error) {
+	c := Clients{
+		assetAPI:           newAPI(AssetAPI, opts),
+		iamAPI:             newAPI(IAMAPI, opts),
+		cloudIdentityAPI:   newAPI(CloudIdentityAPI, opts),
+		directoryAPI:       newAPI(DirectoryAPI, opts),
+		resourceManagerAPI: newAPI(ResourceManagerAPI, opts),
+		membershipWorkers:  opts.MembershipWorkers,
+		readTime:           opts.ReadTime,
+	}
+	if c.membershipWorkers <= 0 {
+		c.membershipWorkers = DefaultMembershipWorkers
+	}
+
+	var err error
+	if c.Asset, err = asset.NewClient(ctx, option.WithGRPCDialOption(grpc.WithUnaryInterceptor(c.assetAPI.unaryInterceptor()))); err != nil {
+		return nil, fmt.Errorf("asset.NewClient: %v", err)
+	}
+	if c.IAM, err = iamadmin.NewIamClient(ctx, option.WithGRPCDialOption(grpc.WithUnaryInterceptor(c.iamAPI.unaryInterceptor()))); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to create IAM client: %v", err)
+	}
+	// The interceptors apply the retry policy: drop the default per-method timeouts and retries of the generated
+	// clients, which would otherwise cut the retries of the policy short.
+	*c.Asset.CallOptions = asset.CallOptions{}
+	*c.IAM.CallOptions = iamadmin.IamCallOptions{}
+	if c.CloudIdentity, err = cloudidentity.NewService(ctx, option.WithQuotaProject(opts.QuotaProjectID)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cloudidentity.NewService: %v", err)
+	}
+	if c.Directory, err = admin.NewService(ctx, option.WithQuotaProject(opts.QuotaProjectID)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("admin.NewService: %v", err)
+	}
+	if c.ResourceManager, err = cloudresourcemanager.NewService(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cloudresourcemanager.NewService: %v", err)
+	}
+	return &c, nil
+}
+
+// Stats returns the number of calls and attempts per API method so far.
+func (c *Clients) Stats() []APIStats {
+	return []APIStats{c.assetAPI.stats(), c.iamAPI.stats(), c.cloudIdentityAPI.stats(), c.directoryAPI.stats(), c.resourceManagerAPI.stats()}
+}
+
+func (c *Clients) Close() error {
+	var err error
+	if c.Asset != nil {
+		err = c.Asset.Close()
+	}
+	if c.IAM != nil {
+		if closeErr := c.IAM.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package gcp
+
+import (
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"strings"
+	"unicode"
+)
+
+// Results of EvaluateTagCondition, as stored in resource_role_principal.condition_result.
+const (
+	ConditionTrue    = "true"
+	ConditionFalse   = "false"
+	ConditionUnknown = "unknown"
+)
+
+// EvaluateTagCondition evaluates an IAM condition against the effective tags of a resource. It only knows the
+// resource.matchTag and resource.matchTagId functions, combined with &&, || and !: any other term, such as a check of
+// request.time or resource.name, is unknown, and so is the result unless the tag checks decide it on their own, e.g.
+// a false matchTag in a conjunction. A condition it can't parse is unknown too.
+func EvaluateTagCondition(expression string, tags []model.ResourceTag) string {
+	tokens, ok := tokenizeCondition(expression)
+	if !ok {
+		return ConditionUnknown
+	}
+	p := &conditionParser{tokens: tokens, tags: tags, ok: true}
+	result := p.or()
+	if !p.ok || p.pos != len(p.tokens) {
+		return ConditionUnknown
+	}
+	return result
+}
+
+// conditionToken is an operator, a parenthesis, a comma, a string literal or any other run of characters.
+type conditionToken struct {
+	text    string
+	literal bool
+}
+
+func tokenizeCondition(expression string) ([]conditionToken, bool) {
+	var tokens []conditionToken
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(expression) && expression[end] != c {
+				if expression[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expression) {
+				return nil, false
+			}
+			tokens = append(tokens, conditionToken{text: unescapeLiteral(expression[i+1 : end]), literal: true})
+			i = end + 1
+		case strings.HasPrefix(expression[i:], "&&"), strings.HasPrefix(expression[i:], "||"):
+			tokens = append(tokens, conditionToken{text: expression[i : i+2]})
+			i += 2
+		case c == '!' && !strings.HasPrefix(expression[i:], "!="), c == '(', c == ')', c == ',':
+			tokens = append(tokens, conditionToken{text: string(c)})
+			i++
+		case c == '?':
+			// Ternaries would need precedence rules this evaluator doesn't have.
+			return nil, false
+		default:
+			end := i + 1
+			for end < len(expression) && !strings.ContainsRune(" \t\r\n\"'&|!(),?", rune(expression[end])) {
+				end++
+			}
+			if strings.HasPrefix(expression[i:], "!=") {
+				end = i + 2
+			}
+			tokens = append(tokens, conditionToken{text: expression[i:end]})
+			i = end
+		}
+	}
+	return tokens, true
+}
+
+// unescapeLiteral resolves the backslash escapes of a string literal: \n, \r and \t, while any other escaped
+// character, such as a quote or a backslash, stands for itself.
+func unescapeLiteral(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// conditionParser evaluates the tokens of a condition as it parses them, with three-valued logic.
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	tags   []model.ResourceTag
+	ok     bool
+}
+
+func (p *conditionParser) peek(offset int) conditionToken {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return conditionToken{}
+}
+
+// operator tells whether the next token is the given operator or parenthesis rather than a literal.
+func (p *conditionParser) operator(offset int, text string) bool {
+	t := p.peek(offset)
+	return !t.literal && t.text == text && p.pos+offset < len(p.tokens)
+}
+
+func (p *conditionParser) or() string {
+	result := p.and()
+	for p.ok && p.operator(0, "||") {
+		p.pos++
+		right := p.and()
+		switch {
+		case result == ConditionTrue || right == ConditionTrue:
+			result = ConditionTrue
+		case result == ConditionUnknown || right == ConditionUnknown:
+			result = ConditionUnknown
+		}
+	}
+	return result
+}
+
+func (p *conditionParser) and() string {
+	result := p.unary()
+	for p.ok && p.operator(0, "&&") {
+		p.pos++
+		right := p.unary()
+		switch {
+		case result == ConditionFalse || right == ConditionFalse:
+			result = ConditionFalse
+		case result == ConditionUnknown || right == ConditionUnknown:
+			result = ConditionUnknown
+		}
+	}
+	return result
+}
+
+func (p *conditionParser) unary() string {
+	if !p.operator(0, "!") {
+		return p.primary()
+	}
+	p.pos++
+	switch p.unary() {
+	case ConditionTrue:
+		return ConditionFalse
+	case ConditionFalse:
+		return ConditionTrue
+	}
+	return ConditionUnknown
+}
+
+func (p *conditionParser) primary() string {
+	if p.operator(0, "(") {
+		p.pos++
+		result := p.or()
+		if !p.ok || !p.operator(0, ")") {
+			p.ok = false
+			return ConditionUnknown
+		}
+		p.pos++
+		return result
+	}
+
+	// A tag function whose result isn't compared or combined with anything but && and ||.
+	function := p.peek(0)
+	if !function.literal && (function.text == "resource.matchTag" || function.text == "resource.matchTagId") &&
+		p.operator(1, "(") && p.peek(2).literal && p.operator(3, ",") && p.peek(4).literal && p.operator(5, ")") &&
+		(p.pos+6 == len(p.tokens) || p.operator(6, "&&") || p.operator(6, "||") || p.operator(6, ")")) {
+		key, value := p.peek(2).text, p.peek(4).text
+		p.pos += 6
+		if function.text == "resource.matchTag" {
+			return matchTag(p.tags, key, value)
+		}
+		return matchTagID(p.tags, key, value)
+	}
+
+	// Any other term runs until the next && or || outside of parentheses.
+	start, depth := p.pos, 0
+	for ; p.pos < len(p.tokens); p.pos++ {
+		if p.operator(0, "(") {
+			depth++
+		} else if p.operator(0, ")") {
+			if depth == 0 {
+				break
+			}
+			depth--
+		} else if depth == 0 && (p.operator(0, "&&") || p.operator(0, "||")) {
+			break
+		}
+	}
+	if p.pos == start || depth > 0 {
+		p.ok = false
+	}
+	return ConditionUnknown
+}
+
+// matchTag tells whether the resource has the value named shortName of the key namespaced keyName, e.g.
+// resource.matchTag('123456789/env', 'prod').
+func matchTag(tags []model.ResourceTag, keyName, shortName string) string {
+	for _, tag := range tags {
+		if tag.Key == keyName && tag.Value == keyName+"/"+shortName {
+			return ConditionTrue
+		}
+	}
+	return ConditionFalse
+}
+
+// matchTagID tells whether the resource has the value valueID of the key keyID, e.g.
+// resource.matchTagId('tagKeys/123', 'tagValues/456'). The key of a value is unknown if tag keys weren't collected.
+func matchTagID(tags []model.ResourceTag, keyID, valueID string) string {
+	for _, tag := range tags {
+		if tag.ValueID != valueID {
+			continue
+		}
+		if tag.KeyID == "" {
+			return ConditionUnknown
+		}
+		if tag.KeyID == keyID {
+			return ConditionTrue
+		}
+	}
+	return ConditionFalse
+}
@@ -0,0 +1,131 @@
+package gcp
+
+import (
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"testing"
+)
+
+func TestEvaluateTagCondition(t *testing.T) {
+	tags := []model.ResourceTag{
+		{Key: "123/env", KeyID: "tagKeys/1", Value: "123/env/prod", ValueID: "tagValues/11"},
+		// The key of this value is unknown, as when tag keys are not collected.
+		{Key: "123/team", Value: "123/team/data", ValueID: "tagValues/21"},
+		{Key: "123/quote", KeyID: "tagKeys/3", Value: "123/quote/it's \"x\"", ValueID: "tagValues/31"},
+	}
+	// Terms whose result is known, to combine in the cases below.
+	const (
+		yes   = `resource.matchTag('123/env', 'prod')`
+		no    = `resource.matchTag('123/env', 'dev')`
+		maybe = `request.time < timestamp("2030-01-01T00:00:00Z")`
+	)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+	}{
+		// matchTag
+		{"matchTag", yes, ConditionTrue},
+		{"matchTag other value", no, ConditionFalse},
+		{"matchTag other key", `resource.matchTag('123/team', 'prod')`, ConditionFalse},
+		{"matchTag missing key", `resource.matchTag('123/cost', 'prod')`, ConditionFalse},
+		{"matchTag value given namespaced", `resource.matchTag('123/env', '123/env/prod')`, ConditionFalse},
+		{"matchTag double quotes", `resource.matchTag("123/env", "prod")`, ConditionTrue},
+		{"matchTag spaces", `  resource.matchTag ( '123/env' ,'prod' )  `, ConditionTrue},
+		{"matchTag key not a literal", `resource.matchTag(key, 'prod')`, ConditionUnknown},
+		{"matchTag one argument", `resource.matchTag('123/env')`, ConditionUnknown},
+
+		// matchTagId
+		{"matchTagId", `resource.matchTagId('tagKeys/1', 'tagValues/11')`, ConditionTrue},
+		{"matchTagId other value", `resource.matchTagId('tagKeys/1', 'tagValues/12')`, ConditionFalse},
+		{"matchTagId other key", `resource.matchTagId('tagKeys/2', 'tagValues/11')`, ConditionFalse},
+		{"matchTagId key unknown", `resource.matchTagId('tagKeys/2', 'tagValues/21')`, ConditionUnknown},
+		{"matchTagId given names", `resource.matchTagId('123/env', 'prod')`, ConditionFalse},
+		{"matchTag given IDs", `resource.matchTag('tagKeys/1', 'tagValues/11')`, ConditionFalse},
+
+		// Quoting and escapes
+		{"escaped single quote", `resource.matchTag('123/quote', 'it\'s "x"')`, ConditionTrue},
+		{"escaped double quote", `resource.matchTag("123/quote", "it's \"x\"")`, ConditionTrue},
+		{"escaped backslash", `resource.matchTag('123/quote', 'it\\'s "x"')`, ConditionUnknown},
+		{"operators in literal", `resource.name.startsWith("a && b || !c") || ` + yes, ConditionTrue},
+		{"parenthesis in literal", `resource.name == "x)" && ` + no, ConditionFalse},
+		{"parenthesis in literal alone", `resource.name == "(x"`, ConditionUnknown},
+
+		// !
+		{"not true", "!" + yes, ConditionFalse},
+		{"not false", "!" + no, ConditionTrue},
+		{"not unknown", "!" + maybe, ConditionUnknown},
+		{"double negation", "!!" + yes, ConditionTrue},
+		{"not parenthesized", "!(" + yes + " && " + no + ")", ConditionTrue},
+
+		// &&
+		{"true and true", yes + " && " + yes, ConditionTrue},
+		{"true and false", yes + " && " + no, ConditionFalse},
+		{"false and unknown", no + " && " + maybe, ConditionFalse},
+		{"unknown and false", maybe + " && " + no, ConditionFalse},
+		{"true and unknown", yes + " && " + maybe, ConditionUnknown},
+		{"unknown and true", maybe + " && " + yes, ConditionUnknown},
+
+		// ||
+		{"false or false", no + " || " + no, ConditionFalse},
+		{"false or true", no + " || " + yes, ConditionTrue},
+		{"true or unknown", yes + " || " + maybe, ConditionTrue},
+		{"unknown or true", maybe + " || " + yes, ConditionTrue},
+		{"false or unknown", no + " || " + maybe, ConditionUnknown},
+		{"unknown or false", maybe + " || " + no, ConditionUnknown},
+
+		// Precedence and parentheses
+		{"and before or, right", yes + " || " + no + " && " + no, ConditionTrue},
+		{"and before or, left", no + " && " + yes + " || " + yes, ConditionTrue},
+		{"parentheses override precedence", "(" + yes + " || " + no + ") && " + no, ConditionFalse},
+		{"nested parentheses", "((" + no + ") || (" + yes + " && (" + yes + ")))", ConditionTrue},
+		{"not binds tighter than and", "!" + no + " && " + no, ConditionFalse},
+
+		// Comparisons
+		{"tag function compared", yes + " == true", ConditionUnknown},
+		{"tag function compared to false", no + " == false", ConditionUnknown},
+		{"equality", `resource.type == "storage.googleapis.com/Bucket"`, ConditionUnknown},
+		{"inequality and false", `resource.type != "storage.googleapis.com/Bucket" && ` + no, ConditionFalse},
+		{"inequality without spaces or true", `resource.type!="x" || ` + yes, ConditionTrue},
+
+		// Unknown terms
+		{"request time", maybe, ConditionUnknown},
+		{"resource name", `resource.name.startsWith("projects/_/buckets/x")`, ConditionUnknown},
+		{"literal true", "true", ConditionUnknown},
+		{"other function", `resource.hasTagKey('123/env')`, ConditionUnknown},
+		{"unknown term in parentheses", "(" + maybe + ")", ConditionUnknown},
+
+		// Unparsable
+		{"empty", "", ConditionUnknown},
+		{"unbalanced open", "(" + yes, ConditionUnknown},
+		{"unbalanced close", yes + ")", ConditionUnknown},
+		{"empty parentheses", "()", ConditionUnknown},
+		{"dangling and", yes + " &&", ConditionUnknown},
+		{"leading or", "|| " + yes, ConditionUnknown},
+		{"lone not", "!", ConditionUnknown},
+		{"unterminated literal", `resource.matchTag('123/env', 'prod)`, ConditionUnknown},
+		{"escaped closing quote", `resource.matchTag('123/env', 'prod\')`, ConditionUnknown},
+		{"ternary", yes + " ? true : false", ConditionUnknown},
+		{"unbalanced parenthesis in unknown term", `timestamp("2030-01-01T00:00:00Z" && ` + yes, ConditionUnknown},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EvaluateTagCondition(tt.expression, tags); got != tt.want {
+				t.Errorf("EvaluateTagCondition(%s) = %s, want %s", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateTagConditionWithoutTags(t *testing.T) {
+	tests := map[string]string{
+		`resource.matchTag('123/env', 'prod')`:              ConditionFalse,
+		`!resource.matchTagId('tagKeys/1', 'tagValues/11')`: ConditionTrue,
+	}
+	for expression, want := range tests {
+		if got := EvaluateTagCondition(expression, nil); got != want {
+			t.Errorf("EvaluateTagCondition(%s) = %s, want %s", expression, got, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package gcp
+
+import (
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/protobuf/types/known/structpb"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountAssetType = "iam.googleapis.com/ServiceAccount"
+	roleAssetType           = "iam.googleapis.com/Role"
+)
+
+// The functions below convert assets, as listed by ListAssets or written by ExportAssets, into records.
+
+func hierarchyFromAsset(asset *assetpb.Asset) (model.Hierarchy, error) {
+	data := asset.GetResource().GetData().GetFields()
+	var name string
+	switch asset.AssetType {
+	case "cloudresourcemanager.googleapis.com/Project":
+		name = data["projectId"].GetStringValue()
+	case "cloudresourcemanager.googleapis.com/Folder", "cloudresourcemanager.googleapis.com/Organization":
+		name = data["displayName"].GetStringValue()
+	default:
+		return model.Hierarchy{}, fmt.Errorf("unknown hierarchy type: %s", asset.AssetType)
+	}
+	return model.Hierarchy{
+		ID:       strings.TrimPrefix(asset.Name, "//cloudresourcemanager.googleapis.com/"),
+		Name:     name,
+		Type:     strings.ToLower(strings.TrimPrefix(asset.AssetType, "cloudresourcemanager.googleapis.com/")),
+		ParentID: strings.TrimPrefix(asset.GetResource().GetParent(), "//cloudresourcemanager.googleapis.com/"),
+	}, nil
+}
+
+func serviceAccountFromAsset(asset *assetpb.Asset) model.Principal {
+	email := asset.GetResource().GetData().GetFields()["email"].GetStringValue()
+	return model.Principal{ID: email, Name: email, Type: "serviceAccount"}
+}
+
+func customRoleFromAsset(asset *assetpb.Asset) model.Role {
+	data := asset.GetResource().GetData().GetFields()
+	id := strings.TrimPrefix(asset.Name, "//iam.googleapis.com/")
+	return model.Role{
+		ID:          id,
+		Title:       data["title"].GetStringValue(),
+		Description: data["description"].GetStringValue(),
+		Stage:       data["stage"].GetStringValue(),
+		Deleted:     data["deleted"].GetBoolValue(),
+		Etag:        data["etag"].GetStringValue(),
+		Parent:      roleParent(id),
+		Permissions: stringList(data["includedPermissions"]),
+	}
+}
+
+// policyFromAsset converts the IAM policy of an asset. Unlike search results, assets list their ancestors in order.
+func policyFromAsset(asset *assetpb.Asset) IAMPolicy {
+	return IAMPolicy{
+		ResourceID: asset.Name,
+		Ancestors:  hierarchyAncestors(asset.Ancestors),
+		Bindings:   bindingsFromPolicy(asset.Name, asset.AssetType, ancestorsHierarchyID(asset.Ancestors), asset.IamPolicy),
+	}
+}
+
+// resourceFromAsset converts any asset into a resource. Unlike search results, listed and exported assets hold the
+// resource as the API of its service returns it, so the display name, state and creation time are read from the
+// fields that most APIs name alike, and left empty otherwise.
+func resourceFromAsset(asset *assetpb.Asset) model.Resource {
+	data := asset.GetResource().GetData().GetFields()
+	resource := model.Resource{
+		Name:        asset.Name,
+		AssetType:   asset.AssetType,
+		Location:    asset.GetResource().GetLocation(),
+		DisplayName: firstString(data, "displayName", "name"),
+		State:       firstString(data, "state", "lifecycleState", "status"),
+		HierarchyID: ancestorsHierarchyID(asset.Ancestors),
+		Ancestors:   hierarchyAncestors(asset.Ancestors),
+	}
+	for _, ancestor := range asset.Ancestors {
+		if strings.HasPrefix(ancestor, "projects/") {
+			resource.Project = ancestor
+			break
+		}
+	}
+	for key, value := range data["labels"].GetStructValue().GetFields() {
+		if resource.Labels == nil {
+			resource.Labels = map[string]string{}
+		}
+		resource.Labels[key] = value.GetStringValue()
+	}
+	if createTime, err := time.Parse(time.RFC3339Nano, firstString(data, "createTime", "creationTimestamp", "timeCreated")); err == nil {
+		resource.CreateTime = createTime
+	}
+	return resource
+}
+
+// firstString returns the first of the given fields holding a non-empty string.
+func firstString(fields map[string]*structpb.Value, names ...string) string {
+	for _, name := range names {
+		if value := fields[name].GetStringValue(); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ancestorsHierarchyID returns the project, folder or organization closest to an asset, given its ancestors
+// listed from the asset itself up to its organization.
+func ancestorsHierarchyID(ancestors []string) string {
+	for _, ancestor := range ancestors {
+		if isHierarchyID(ancestor) {
+			return ancestor
+		}
+	}
+	return ""
+}
+
+// hierarchyAncestors returns the projects, folders and organization among the ancestors of an asset.
+func hierarchyAncestors(ancestors []string) []string {
+	var hierarchy []string
+	for _, ancestor := range ancestors {
+		if isHierarchyID(ancestor) {
+			hierarchy = append(hierarchy, ancestor)
+		}
+	}
+	return hierarchy
+}
+
+// isHierarchyID tells whether id names a project, folder or organization, rather than e.g. a tag value.
+func isHierarchyID(id string) bool {
+	return strings.HasPrefix(id, "projects/") || strings.HasPrefix(id, "folders/") || strings.HasPrefix(id, "organizations/")
+}
+
+// stringList returns the strings of a list value, skipping other kinds of values.
+func stringList(value *structpb.Value) []string {
+	var values []string
+	for _, v := range value.GetListValue().GetValues() {
+		if s, ok := v.GetKind().(*structpb.Value_StringValue); ok {
+			values = append(values, s.StringValue)
+		}
+	}
+	return values
+}
@@ -1,48 +1,114 @@
 package gcp
 
 import (
-	admin "cloud.google.com/go/iam/admin/apiv1"
 	"cloud.google.com/go/iam/admin/apiv1/adminpb"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/grpc/codes"
+	"log"
+	"slices"
+	"strings"
 )
 
-// listBuiltInRoles fetches all built-in roles in GCP.
+// FetchCustomRoles fetches the custom roles defined in every scope.
+func (c *Clients) FetchCustomRoles(ctx context.Context, scopes []string) ([]model.Role, error) {
+	var roles []model.Role
+	for _, scope := range scopes {
+		customRoles, err := c.fetchCustomRoles(ctx, scope)
+		if err != nil {
+			return nil, fmt.Errorf("fetching custom roles of %s: %v", scope, err)
+		}
+		roles = append(roles, customRoles...)
+	}
+	if !c.readTime.IsZero() {
+		// Listed assets hold the whole role, as of the read time the IAM API can't read at.
+		return roles, nil
+	}
+	return c.completeCustomRoles(ctx, roles)
+}
 
-func FetchAllRoles(ctx context.Context, scope string) ([]model.Role, error) {
-	predefinedRoles, err := fetchPredefinedRoles(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch predefined roles: %v", err)
+// completeCustomRoles lists again with the IAM API the roles of every organization and project defining some of the
+// searched roles, since search results lack their launch stage and etag, and deleted roles, which bindings may still
+// reference. The searched roles are kept when the caller may not list those of their parent.
+func (c *Clients) completeCustomRoles(ctx context.Context, searched []model.Role) ([]model.Role, error) {
+	var parents []string
+	byParent := map[string][]model.Role{}
+	for _, role := range searched {
+		if _, ok := byParent[role.Parent]; !ok {
+			parents = append(parents, role.Parent)
+		}
+		byParent[role.Parent] = append(byParent[role.Parent], role)
 	}
-	customRoles, err := fetchCustomRoles(ctx, scope)
+
+	roles, denied, err := c.listParentRoles(ctx, parents)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch predefined roles: %v", err)
+		return nil, err
+	}
+	for _, parent := range denied {
+		roles = append(roles, byParent[parent]...)
 	}
-	return append(predefinedRoles, customRoles...), nil
+	return roles, nil
 }
 
-func fetchPredefinedRoles(ctx context.Context) ([]model.Role, error) {
-	iamClient, err := admin.NewIamClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create IAM client: %v", err)
+// FetchReferencedRoles lists the custom roles of the organizations and projects defining the given roles, such as
+// the roles of projects outside the dumped scopes that bindings grant. Predefined roles are left out, and so are the
+// parents the caller may not list.
+func (c *Clients) FetchReferencedRoles(ctx context.Context, roleIDs []string) ([]model.Role, error) {
+	var parents []string
+	for _, id := range roleIDs {
+		if parent := roleParent(id); parent != "" && !slices.Contains(parents, parent) {
+			parents = append(parents, parent)
+		}
+	}
+	roles, _, err := c.listParentRoles(ctx, parents)
+	return roles, err
+}
+
+// listParentRoles lists the roles of every parent, and returns the parents the caller may not list apart.
+func (c *Clients) listParentRoles(ctx context.Context, parents []string) ([]model.Role, []string, error) {
+	var roles []model.Role
+	var denied []string
+	for _, parent := range parents {
+		listed, err := c.listRoles(ctx, parent)
+		if code := errorCode(err); code == codes.PermissionDenied || code == codes.NotFound {
+			log.Printf("Failed to list the custom roles of %s: %v", parent, err)
+			denied = append(denied, parent)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list roles of %s: %v", parent, err)
+		}
+		roles = append(roles, listed...)
 	}
-	defer iamClient.Close()
+	return roles, denied, nil
+}
+
+// FetchPredefinedRoles fetches the roles managed by Google, which are not assets of any organization.
+func (c *Clients) FetchPredefinedRoles(ctx context.Context) ([]model.Role, error) {
+	return c.listRoles(ctx, "")
+}
 
+// listRoles lists the roles defined by parent, an organization or a project, deleted ones included, or the
+// predefined roles if parent is empty.
+func (c *Clients) listRoles(ctx context.Context, parent string) ([]model.Role, error) {
 	var rolesBatch []*adminpb.Role
 	var roles []model.Role
 	nextPageToken := ""
 	for {
 		req := &adminpb.ListRolesRequest{
-			//Parent: "organizations/0", // Use "organizations/0" for Google-managed rolesBatch
-			PageToken: nextPageToken,
-			PageSize:  1000,
-			View:      adminpb.RoleView_FULL,
+			Parent:      parent,
+			PageToken:   nextPageToken,
+			PageSize:    1000,
+			View:        adminpb.RoleView_FULL,
+			ShowDeleted: parent != "",
 		}
 
-		resp, err := iamClient.ListRoles(ctx, req)
+		resp, err := c.IAM.ListRoles(ctx, req)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list rolesBatch: %v", err)
+			// Not wrapped: callers tell permission errors apart.
+			return nil, err
 		}
 
 		rolesBatch = append(rolesBatch, resp.Roles...)
@@ -56,6 +122,11 @@ func fetchPredefinedRoles(ctx context.Context) ([]model.Role, error) {
 		roles = append(roles, model.Role{
 			ID:          role.Name,
 			Title:       role.Title,
+			Description: role.Description,
+			Stage:       role.Stage.String(),
+			Deleted:     role.Deleted,
+			Etag:        base64.StdEncoding.EncodeToString(role.Etag),
+			Parent:      parent,
 			Permissions: role.IncludedPermissions,
 		})
 
@@ -63,3 +134,53 @@ func fetchPredefinedRoles(ctx context.Context) ([]model.Role, error) {
 
 	return roles, nil
 }
+
+// FetchPermissions returns the permissions that can be tested on the given organizations or projects, or on the
+// resources below them, along with their metadata. They are read as of now, even with a read time.
+func (c *Clients) FetchPermissions(ctx context.Context, parents []string) ([]model.Permission, error) {
+	var permissions []model.Permission
+	indexes := map[string]int{}
+	for _, parent := range parents {
+		nextPageToken := ""
+		for {
+			resp, err := c.IAM.QueryTestablePermissions(ctx, &adminpb.QueryTestablePermissionsRequest{
+				FullResourceName: "//cloudresourcemanager.googleapis.com/" + parent,
+				PageSize:         1000,
+				PageToken:        nextPageToken,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to query the permissions of %s: %v", parent, err)
+			}
+			for _, permission := range resp.Permissions {
+				if i, ok := indexes[permission.Name]; ok {
+					permissions[i].APIDisabled = permissions[i].APIDisabled && permission.ApiDisabled
+					continue
+				}
+				service, _, _ := strings.Cut(permission.Name, ".")
+				indexes[permission.Name] = len(permissions)
+				permissions = append(permissions, model.Permission{
+					Name:                    permission.Name,
+					Title:                   permission.Title,
+					Description:             permission.Description,
+					Service:                 service,
+					Stage:                   permission.Stage.String(),
+					CustomRolesSupportLevel: permission.CustomRolesSupportLevel.String(),
+					APIDisabled:             permission.ApiDisabled,
+				})
+			}
+			if nextPageToken = resp.NextPageToken; nextPageToken == "" {
+				break
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// roleParent returns the organization or project defining a custom role given its ID, e.g. organizations/123 for
+// organizations/123/roles/myRole, and an empty string for predefined roles.
+func roleParent(roleID string) string {
+	if i := strings.Index(roleID, "/roles/"); i > 0 {
+		return roleID[:i]
+	}
+	return ""
+}
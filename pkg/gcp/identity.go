@@ -4,12 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"golang.org/x/sync/errgroup"
 	admin "google.golang.org/api/admin/directory/v1"
 	"google.golang.org/api/cloudidentity/v1"
-	"google.golang.org/api/option"
-	"log"
 	"strings"
-	"sync"
 )
 
 type GroupWithMembers struct {
@@ -18,50 +16,58 @@ type GroupWithMembers struct {
 	Users   []model.Principal
 }
 
-func FetchGroupsMembership(ctx context.Context, groups []model.Principal, projectId string) ([]model.PrincipalRelationship, []model.Principal, error) {
-	service, err := cloudidentity.NewService(ctx, option.WithQuotaProject(projectId))
-	membershipsService := cloudidentity.NewGroupsMembershipsService(service)
-	if err != nil {
-		log.Fatalf("cloudidentity.NewService: %v", err)
-	}
+// FetchGroupsMembership lists the members of every group, using up to ClientOptions.MembershipWorkers concurrent
+// workers. It fails on the first group whose members can't be listed once retries are exhausted.
+func (c *Clients) FetchGroupsMembership(ctx context.Context, groups []model.Principal) ([]model.PrincipalRelationship, []model.Principal, error) {
+	membershipsService := cloudidentity.NewGroupsMembershipsService(c.CloudIdentity)
 
-	var wg sync.WaitGroup
 	groupsWithMembersChan := make(chan GroupWithMembers, len(groups))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.membershipWorkers)
 
 	for _, group := range groups {
-
-		wg.Add(1)
-		go func(group model.Principal) {
-			defer wg.Done()
-
-			req := membershipsService.List(group.ID)
-			resp, err := req.Do()
-			if err != nil {
-				log.Printf("Error listing members for group %s: %v", group.Name, err)
-				return
-			}
-
+		group := group
+		g.Go(func() error {
 			var principalRelationships []model.PrincipalRelationship
 			var principals []model.Principal
-			for _, membership := range resp.Memberships {
-				parts := strings.Split(membership.Name, "/")
-				groupID := parts[0] + "/" + parts[1]
-				memberID := parts[3]
-				memberType := "user"
-				// If the member is a group, its ID contains letters, else it's a user.
-				if strings.ContainsAny(memberID, "abcdefghijklmnopqrstuvwxyz") {
-					memberID = "groups/" + memberID
-					memberType = "group"
+			pageToken := ""
+			for {
+				var resp *cloudidentity.ListMembershipsResponse
+				err := c.cloudIdentityAPI.do(ctx, "groups.memberships.list", func() (err error) {
+					resp, err = membershipsService.List(group.ID).PageToken(pageToken).Context(ctx).Do()
+					return err
+				})
+				if err != nil {
+					return fmt.Errorf("error listing members for group %s: %v", group.Name, err)
+				}
+
+				for _, membership := range resp.Memberships {
+					parts := strings.Split(membership.Name, "/")
+					groupID := parts[0] + "/" + parts[1]
+					memberID := parts[3]
+					memberType := "user"
+					// If the member is a group, its ID contains letters, else it's a user.
+					if strings.ContainsAny(memberID, "abcdefghijklmnopqrstuvwxyz") {
+						memberID = "groups/" + memberID
+						memberType = "group"
+					}
+					principalRelationships = append(principalRelationships, model.PrincipalRelationship{ParentID: groupID, ChildID: memberID})
+					principals = append(principals, model.Principal{ID: memberID, Name: membership.PreferredMemberKey.Id, Type: memberType})
+				}
+
+				if pageToken = resp.NextPageToken; pageToken == "" {
+					break
 				}
-				principalRelationships = append(principalRelationships, model.PrincipalRelationship{ParentID: groupID, ChildID: memberID})
-				principals = append(principals, model.Principal{ID: memberID, Name: membership.PreferredMemberKey.Id, Type: memberType})
 			}
 
 			groupsWithMembersChan <- GroupWithMembers{Group: group, Members: principalRelationships, Users: principals}
-		}(group)
+			return nil
+		})
 	}
 
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
 	close(groupsWithMembersChan)
 
 	var principalRelationships []model.PrincipalRelationship
@@ -74,36 +80,47 @@ func FetchGroupsMembership(ctx context.Context, groups []model.Principal, projec
 	return principalRelationships, principals, nil
 }
 
-func FetchGroups(ctx context.Context, projectId, organization string) ([]model.Principal, error) {
-	service, err := cloudidentity.NewService(ctx, option.WithQuotaProject(projectId))
-	if err != nil {
-		log.Fatalf("cloudidentity.NewService: %v", err)
-	}
-	groupsService := cloudidentity.NewGroupsService(service)
-	req := groupsService.List().Parent(fmt.Sprintf("customers/%s", organization))
-	resp, err := req.Do()
-	if err != nil {
-		return nil, err
-	}
+func (c *Clients) FetchGroups(ctx context.Context, organization string) ([]model.Principal, error) {
+	groupsService := cloudidentity.NewGroupsService(c.CloudIdentity)
 
 	var principals []model.Principal
-	for _, group := range resp.Groups {
-		principals = append(principals, model.Principal{ID: group.Name, Name: group.GroupKey.Id, Type: "group"})
+	pageToken := ""
+	for {
+		var resp *cloudidentity.ListGroupsResponse
+		err := c.cloudIdentityAPI.do(ctx, "groups.list", func() (err error) {
+			resp, err = groupsService.List().Parent(fmt.Sprintf("customers/%s", organization)).PageToken(pageToken).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range resp.Groups {
+			principals = append(principals, model.Principal{ID: group.Name, Name: group.GroupKey.Id, Type: "group"})
+		}
+
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			break
+		}
 	}
 
 	return principals, nil
 }
 
-func FetchUsers(ctx context.Context, projectId, customerID string) ([]model.Principal, error) {
-	service, err := admin.NewService(ctx, option.WithQuotaProject(projectId))
-	if err != nil {
-		return nil, fmt.Errorf("admin.NewService: %v", err)
-	}
-
+func (c *Clients) FetchUsers(ctx context.Context, customerID string) ([]model.Principal, error) {
 	var users []model.Principal
-	// Call the Admin SDK Directory API
-	req := service.Users.List().Customer(customerID).MaxResults(500)
-	err = req.Pages(ctx, func(page *admin.Users) error {
+	pageToken := ""
+	for {
+		// Call the Admin SDK Directory API
+		var page *admin.Users
+		err := c.directoryAPI.do(ctx, "users.list", func() (err error) {
+			page, err = c.Directory.Users.List().Customer(customerID).MaxResults(500).PageToken(pageToken).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Users.List: %v", err)
+		}
+
 		for _, user := range page.Users {
 			users = append(users, model.Principal{
 				ID:   user.Id,
@@ -111,11 +128,10 @@ func FetchUsers(ctx context.Context, projectId, customerID string) ([]model.Prin
 				Type: "user",
 			})
 		}
-		return nil
-	})
 
-	if err != nil {
-		return nil, fmt.Errorf("Users.List: %v", err)
+		if pageToken = page.NextPageToken; pageToken == "" {
+			break
+		}
 	}
 
 	return users, nil
@@ -0,0 +1,93 @@
+package gcp
+
+import (
+	"bufio"
+	"bytes"
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"errors"
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"slices"
+)
+
+// AssetRecords holds the records converted from the assets of a Cloud Asset Inventory export.
+type AssetRecords struct {
+	// TenantID is the tenant the records are tagged with, set before adding assets.
+	TenantID    string
+	Hierarchies []model.Hierarchy
+	Principals  []model.Principal
+	Roles       []model.Role
+	Bindings    []model.ResourceIAMPermission
+	Resources   []model.Resource
+	Ancestors   []model.ResourceAncestor
+}
+
+// Add converts an asset into records. Exports hold one line per asset and content type, so an asset adds either
+// its resource, along with its hierarchy node, service account or custom role if it is one, or the bindings of its
+// IAM policy.
+func (r *AssetRecords) Add(asset *assetpb.Asset) error {
+	if asset.Resource != nil {
+		resource := resourceFromAsset(asset)
+		resource.TenantID = r.TenantID
+		r.Resources = append(r.Resources, resource)
+		switch {
+		case slices.Contains(hierarchyAssetTypes, asset.AssetType):
+			hierarchy, err := hierarchyFromAsset(asset)
+			if err != nil {
+				return err
+			}
+			hierarchy.TenantID = r.TenantID
+			r.Hierarchies = append(r.Hierarchies, hierarchy)
+		case asset.AssetType == serviceAccountAssetType:
+			principal := serviceAccountFromAsset(asset)
+			principal.TenantID = r.TenantID
+			r.Principals = append(r.Principals, principal)
+		case asset.AssetType == roleAssetType:
+			role := customRoleFromAsset(asset)
+			role.TenantID = r.TenantID
+			r.Roles = append(r.Roles, role)
+		}
+	}
+	if asset.IamPolicy != nil {
+		policy := policyFromAsset(asset)
+		for _, binding := range policy.Bindings {
+			binding.TenantID = r.TenantID
+			r.Bindings = append(r.Bindings, binding)
+		}
+		r.Ancestors = append(r.Ancestors, policy.AncestorRecords(r.TenantID)...)
+	}
+	return nil
+}
+
+// ReadAssetExport decodes the newline-delimited JSON written by the ExportAssets method of the Asset API and calls
+// handle with batches of up to batchSize assets.
+func ReadAssetExport(r io.Reader, batchSize int, handle func([]*assetpb.Asset) error) error {
+	decoder := protojson.UnmarshalOptions{DiscardUnknown: true}
+	reader := bufio.NewReader(r)
+	batch := make([]*assetpb.Asset, 0, batchSize)
+	for lineNumber := 1; ; lineNumber++ {
+		// Lines are read whole rather than scanned, as the IAM policy of a single asset can exceed any scanner buffer.
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			asset := &assetpb.Asset{}
+			if err := decoder.Unmarshal(line, asset); err != nil {
+				return fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			batch = append(batch, asset)
+		}
+		if len(batch) == batchSize || (errors.Is(err, io.EOF) && len(batch) > 0) {
+			if err := handle(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
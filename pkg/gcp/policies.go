@@ -0,0 +1,36 @@
+package gcp
+
+import (
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"context"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"time"
+)
+
+// IAMPolicyAsset is the IAM policy of a resource along with when it last changed.
+type IAMPolicyAsset struct {
+	IAMPolicy
+	UpdateTime time.Time
+}
+
+// FetchIAMPolicyAssets sends the IAM policy of every resource in scope to out, one page at a time. Unlike the
+// policies searched by FetchAssetIAMPolicy, listed policies carry their update time, which lets dumps keep the bindings
+// of unchanged resources. ListAssets can't filter on it: every policy is listed. It closes out when it returns.
+func (c *Clients) FetchIAMPolicyAssets(ctx context.Context, scope string, out chan<- model.Page[IAMPolicyAsset]) error {
+	defer close(out)
+	return c.listAssets(ctx, scope, assetpb.ContentType_IAM_POLICY, nil, "", func(assets []*assetpb.Asset, nextPageToken string) error {
+		page := model.Page[IAMPolicyAsset]{NextPageToken: nextPageToken}
+		for _, asset := range assets {
+			page.Items = append(page.Items, IAMPolicyAsset{
+				IAMPolicy:  policyFromAsset(asset),
+				UpdateTime: asset.UpdateTime.AsTime(),
+			})
+		}
+		select {
+		case out <- page:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package gcp
+
+import (
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"context"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+// MaxReadTimeAge is how far back the Asset API keeps the history that ListAssets can read.
+const MaxReadTimeAge = 35 * 24 * time.Hour
+
+// listPageSize is the number of assets per page of ListAssets, its maximum.
+const listPageSize = 1000
+
+// The search methods of the Asset API only ever return the current state of assets. When ClientOptions.ReadTime
+// is set, the Asset collectors list assets with ListAssets instead, which reads them as of that time.
+
+// listAssets pages through the assets of the given types in scope, as of the read time of the clients if set,
+// starting from pageToken (the first page if empty). It calls page with every page of assets.
+func (c *Clients) listAssets(ctx context.Context, scope string, contentType assetpb.ContentType, assetTypes []string, pageToken string, page func(assets []*assetpb.Asset, nextPageToken string) error) error {
+	req := &assetpb.ListAssetsRequest{
+		Parent:      scope, // e.g., "organizations/123456789"
+		ContentType: contentType,
+		AssetTypes:  assetTypes,
+	}
+	if !c.readTime.IsZero() {
+		req.ReadTime = timestamppb.New(c.readTime)
+	}
+	pager := iterator.NewPager(c.Asset.ListAssets(ctx, req), listPageSize, pageToken)
+	for {
+		var assets []*assetpb.Asset
+		nextPageToken, err := pager.NextPage(&assets)
+		if err != nil {
+			return err
+		}
+		if err := page(assets, nextPageToken); err != nil {
+			return err
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+	}
+}
+
+func (c *Clients) listHierarchies(ctx context.Context, scope string) ([]model.Hierarchy, error) {
+	var hierarchies []model.Hierarchy
+	err := c.listAssets(ctx, scope, assetpb.ContentType_RESOURCE, hierarchyAssetTypes, "", func(assets []*assetpb.Asset, _ string) error {
+		for _, asset := range assets {
+			hierarchy, err := hierarchyFromAsset(asset)
+			if err != nil {
+				return err
+			}
+			hierarchies = append(hierarchies, hierarchy)
+		}
+		return nil
+	})
+	return hierarchies, err
+}
+
+func (c *Clients) listServiceAccounts(ctx context.Context, scope, pageToken string, out chan<- model.Page[model.Principal]) error {
+	return c.listAssets(ctx, scope, assetpb.ContentType_RESOURCE, []string{serviceAccountAssetType}, pageToken, func(assets []*assetpb.Asset, nextPageToken string) error {
+		page := model.Page[model.Principal]{NextPageToken: nextPageToken}
+		for _, asset := range assets {
+			page.Items = append(page.Items, serviceAccountFromAsset(asset))
+		}
+		select {
+		case out <- page:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+func (c *Clients) listCustomRoles(ctx context.Context, scope string) ([]model.Role, error) {
+	var customRoles []model.Role
+	err := c.listAssets(ctx, scope, assetpb.ContentType_RESOURCE, []string{roleAssetType}, "", func(assets []*assetpb.Asset, _ string) error {
+		for _, asset := range assets {
+			customRoles = append(customRoles, customRoleFromAsset(asset))
+		}
+		return nil
+	})
+	return customRoles, err
+}
+
+func (c *Clients) listResources(ctx context.Context, scope, pageToken string, out chan<- model.Page[model.Resource]) error {
+	return c.listAssets(ctx, scope, assetpb.ContentType_RESOURCE, nil, pageToken, func(assets []*assetpb.Asset, nextPageToken string) error {
+		page := model.Page[model.Resource]{NextPageToken: nextPageToken}
+		for _, asset := range assets {
+			page.Items = append(page.Items, resourceFromAsset(asset))
+		}
+		select {
+		case out <- page:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+func (c *Clients) listIAMPolicies(ctx context.Context, scope, pageToken string, out chan<- model.Page[IAMPolicy]) error {
+	return c.listAssets(ctx, scope, assetpb.ContentType_IAM_POLICY, nil, pageToken, func(assets []*assetpb.Asset, nextPageToken string) error {
+		page := model.Page[IAMPolicy]{NextPageToken: nextPageToken}
+		for _, asset := range assets {
+			page.Items = append(page.Items, policyFromAsset(asset))
+		}
+		select {
+		case out <- page:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
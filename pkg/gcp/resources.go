@@ -0,0 +1,69 @@
+package gcp
+
+import (
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"context"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/api/iterator"
+	"time"
+)
+
+// FetchResources sends every resource in scope, whatever its type, to out, one page at a time, starting from
+// pageToken (the first page if empty). It closes out when it returns.
+func (c *Clients) FetchResources(ctx context.Context, scope, pageToken string, out chan<- model.Page[model.Resource]) error {
+	defer close(out)
+	if !c.readTime.IsZero() {
+		return c.listResources(ctx, scope, pageToken, out)
+	}
+
+	req := &assetpb.SearchAllResourcesRequest{
+		Scope: scope, // e.g., "organizations/123456789"
+	}
+	pager := iterator.NewPager(c.Asset.SearchAllResources(ctx, req), searchPageSize, pageToken)
+	for {
+		var resources []*assetpb.ResourceSearchResult
+		nextPageToken, err := pager.NextPage(&resources)
+		if err != nil {
+			return err
+		}
+
+		page := model.Page[model.Resource]{NextPageToken: nextPageToken}
+		for _, resource := range resources {
+			page.Items = append(page.Items, resourceFromSearchResult(resource))
+		}
+		select {
+		case out <- page:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+	}
+}
+
+// resourceFromSearchResult converts a searched resource. Like searched policies, it is attributed to the first of its
+// folders until the hierarchy is known, see db.Storage.ResolveHierarchy.
+func resourceFromSearchResult(resource *assetpb.ResourceSearchResult) model.Resource {
+	var createTime time.Time
+	if resource.CreateTime != nil {
+		createTime = resource.CreateTime.AsTime()
+	}
+	var tags []model.ResourceTag
+	for _, tag := range resource.Tags {
+		tags = append(tags, model.ResourceTag{Key: tag.GetTagKey(), Value: tag.GetTagValue(), ValueID: tag.GetTagValueId()})
+	}
+	return model.Resource{
+		Name:        resource.Name,
+		AssetType:   resource.AssetType,
+		Project:     resource.Project,
+		Location:    resource.Location,
+		Labels:      resource.Labels,
+		DisplayName: resource.DisplayName,
+		State:       resource.State,
+		CreateTime:  createTime,
+		HierarchyID: searchResultHierarchyID(resource.Project, resource.Folders, resource.Organization),
+		Ancestors:   searchResultAncestors(resource.Name, resource.Project, resource.Folders, resource.Organization),
+		Tags:        tags,
+	}
+}
@@ -0,0 +1,148 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RetryPolicy decides which failed API calls are attempted again, and when.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a call, the first one included.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, multiplied by Multiplier at each retry up to MaxBackoff.
+	// Actual delays are drawn between half and all of the computed backoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// RetryableCodes are the gRPC codes of the errors worth retrying. REST errors are mapped to the gRPC code
+	// of their HTTP status.
+	RetryableCodes []codes.Code
+}
+
+// DefaultRetryPolicy retries throttled and unavailable calls for about a minute and a half.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    6,
+	InitialBackoff: time.Second,
+	MaxBackoff:     32 * time.Second,
+	Multiplier:     2,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+}
+
+// withDefaults returns the policy, unset fields taken from DefaultRetryPolicy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.RetryableCodes == nil {
+		p.RetryableCodes = DefaultRetryPolicy.RetryableCodes
+	}
+	return p
+}
+
+// do calls f until it succeeds, fails with an error that is not retryable, or MaxAttempts is reached.
+// It returns the number of attempts made along with the last error.
+func (p RetryPolicy) do(ctx context.Context, f func() error) (int, error) {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) || ctx.Err() != nil {
+			return attempt, err
+		}
+		if err := sleep(ctx, backoff/2+time.Duration(rand.Int63n(int64(backoff/2)+1))); err != nil {
+			return attempt, err
+		}
+		backoff = min(time.Duration(float64(backoff)*p.Multiplier), p.MaxBackoff)
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	code := errorCode(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCode returns the gRPC code of err, translating the HTTP status of REST API errors.
+func errorCode(err error) codes.Code {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return status.Code(err)
+	}
+	switch apiErr.Code {
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusInternalServerError:
+		return codes.Internal
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	default:
+		return codes.Unknown
+	}
+}
+
+// ParseCodes parses gRPC code names such as UNAVAILABLE or RESOURCE_EXHAUSTED, case insensitively.
+func ParseCodes(names []string) ([]codes.Code, error) {
+	parsed := []codes.Code{}
+	for _, name := range names {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(`"` + strings.ToUpper(strings.TrimSpace(name)) + `"`)); err != nil {
+			return nil, fmt.Errorf("unknown code %q", name)
+		}
+		parsed = append(parsed, c)
+	}
+	return parsed, nil
+}
+
+// CodeNames returns the names of gRPC codes as accepted by ParseCodes, e.g. RESOURCE_EXHAUSTED.
+func CodeNames(cs []codes.Code) []string {
+	var names []string
+	for _, c := range cs {
+		var name strings.Builder
+		for i, r := range c.String() {
+			if i > 0 && unicode.IsUpper(r) && c != codes.OK {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+		names = append(names, name.String())
+	}
+	return names
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/grpc/codes"
+	"log"
+	"strings"
+)
+
+// scopePrefixes are the kinds of resources the Asset collectors can be scoped to.
+var scopePrefixes = []string{"organizations/", "folders/", "projects/"}
+
+// ParseScope checks that scope is an organization, folder or project, e.g. folders/123456789.
+func ParseScope(scope string) (string, error) {
+	for _, prefix := range scopePrefixes {
+		if strings.HasPrefix(scope, prefix) && len(scope) > len(prefix) {
+			return scope, nil
+		}
+	}
+	return "", fmt.Errorf("invalid scope %q, expected organizations/<id>, folders/<id> or projects/<id>", scope)
+}
+
+// OrganizationScope returns the scope of an organization given its ID, with or without the organizations/ prefix.
+func OrganizationScope(orgID string) string {
+	return "organizations/" + strings.TrimPrefix(orgID, "organizations/")
+}
+
+// FetchAncestors returns the scopes themselves along with their ancestors, walking up their parents with the
+// Resource Manager API until the organization. Since the Asset API only returns what is inside a scope, this is
+// what ties folder and project scopes to the rest of the hierarchy. The walk stops early, without failing, at the
+// first ancestor the caller is not permitted to read. Ancestors are read as of now, even with a read time.
+func (c *Clients) FetchAncestors(ctx context.Context, scopes []string) ([]model.Hierarchy, error) {
+	var hierarchies []model.Hierarchy
+	visited := map[string]bool{}
+	for _, scope := range scopes {
+		for name := scope; name != "" && !visited[name]; {
+			visited[name] = true
+			hierarchy, err := c.getHierarchy(ctx, name)
+			if code := errorCode(err); code == codes.PermissionDenied || code == codes.NotFound {
+				log.Printf("Stopping at %s the ancestors of %s: %v", name, scope, err)
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("getting %s: %v", name, err)
+			}
+			visited[hierarchy.ID] = true
+			hierarchies = append(hierarchies, hierarchy)
+			name = hierarchy.ParentID
+		}
+	}
+	return hierarchies, nil
+}
+
+// getHierarchy gets an organization, folder or project by name. Projects may be named by number or ID.
+func (c *Clients) getHierarchy(ctx context.Context, name string) (model.Hierarchy, error) {
+	var hierarchy model.Hierarchy
+	err := c.resourceManagerAPI.do(ctx, strings.SplitN(name, "/", 2)[0]+".get", func() error {
+		switch {
+		case strings.HasPrefix(name, "organizations/"):
+			org, err := c.ResourceManager.Organizations.Get(name).Context(ctx).Do()
+			if err != nil {
+				return err
+			}
+			hierarchy = model.Hierarchy{ID: org.Name, Name: org.DisplayName, Type: "organization"}
+		case strings.HasPrefix(name, "folders/"):
+			folder, err := c.ResourceManager.Folders.Get(name).Context(ctx).Do()
+			if err != nil {
+				return err
+			}
+			hierarchy = model.Hierarchy{ID: folder.Name, Name: folder.DisplayName, Type: "folder", ParentID: folder.Parent}
+		case strings.HasPrefix(name, "projects/"):
+			project, err := c.ResourceManager.Projects.Get(name).Context(ctx).Do()
+			if err != nil {
+				return err
+			}
+			hierarchy = model.Hierarchy{ID: project.Name, Name: project.ProjectId, Type: "project", ParentID: project.Parent}
+		default:
+			return fmt.Errorf("unknown hierarchy type of %s", name)
+		}
+		return nil
+	})
+	return hierarchy, err
+}
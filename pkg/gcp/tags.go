@@ -0,0 +1,72 @@
+package gcp
+
+import (
+	"context"
+	"github.com/ttauveron/gcp-iam-dumper/pkg/model"
+	"google.golang.org/api/cloudresourcemanager/v3"
+)
+
+// FetchTags returns the tag keys owned by the given organizations and projects, and the values of these keys. The
+// Resource Manager API has no history: tags are read as of now, even with a read time.
+func (c *Clients) FetchTags(ctx context.Context, parents []string) ([]model.TagKey, []model.TagValue, error) {
+	var keys []model.TagKey
+	var values []model.TagValue
+	for _, parent := range parents {
+		pageToken := ""
+		for {
+			var resp *cloudresourcemanager.ListTagKeysResponse
+			err := c.resourceManagerAPI.do(ctx, "tagKeys.list", func() (err error) {
+				resp, err = c.ResourceManager.TagKeys.List().Parent(parent).PageToken(pageToken).Context(ctx).Do()
+				return err
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, key := range resp.TagKeys {
+				keys = append(keys, model.TagKey{
+					ID:             key.Name,
+					Parent:         key.Parent,
+					ShortName:      key.ShortName,
+					NamespacedName: key.NamespacedName,
+					Description:    key.Description,
+				})
+				keyValues, err := c.fetchTagValues(ctx, key.Name)
+				if err != nil {
+					return nil, nil, err
+				}
+				values = append(values, keyValues...)
+			}
+			if pageToken = resp.NextPageToken; pageToken == "" {
+				break
+			}
+		}
+	}
+	return keys, values, nil
+}
+
+func (c *Clients) fetchTagValues(ctx context.Context, keyID string) ([]model.TagValue, error) {
+	var values []model.TagValue
+	pageToken := ""
+	for {
+		var resp *cloudresourcemanager.ListTagValuesResponse
+		err := c.resourceManagerAPI.do(ctx, "tagValues.list", func() (err error) {
+			resp, err = c.ResourceManager.TagValues.List().Parent(keyID).PageToken(pageToken).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range resp.TagValues {
+			values = append(values, model.TagValue{
+				ID:             value.Name,
+				KeyID:          keyID,
+				ShortName:      value.ShortName,
+				NamespacedName: value.NamespacedName,
+				Description:    value.Description,
+			})
+		}
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			return values, nil
+		}
+	}
+}
@@ -1,34 +1,122 @@
 package model
 
+import "time"
+
 type Hierarchy struct {
 	ID       string
 	Name     string
 	Type     string
 	ParentID string
+	TenantID string
 }
 
 type Principal struct {
 	ID   string
 	Name string
 	Type string
+	// TenantID is the tenant the principal was collected from. A principal may belong to several tenants.
+	TenantID string
 }
 
 type PrincipalRelationship struct {
 	ParentID string
 	ChildID  string
+	TenantID string
 }
 
 type ResourceIAMPermission struct {
 	ResourceID  string
 	PrincipalID string
 	RoleID      string
-	Conditional string
-	AssetType   string
-	HierarchyID string
+	// Conditional is the title of the condition of the binding, if any, and ConditionExpression its CEL expression.
+	Conditional         string
+	ConditionExpression string
+	AssetType           string
+	HierarchyID         string
+	TenantID            string
 }
 
 type Role struct {
 	ID          string
 	Title       string
+	Description string
+	// Stage is the launch stage of the role: ALPHA, BETA, GA, DEPRECATED, DISABLED or EAP.
+	Stage   string
+	Deleted bool
+	Etag    string
+	// Parent is the organization or project defining a custom role, empty for predefined roles.
+	Parent      string
 	Permissions []string
+	// TenantID is empty for predefined roles, which are shared by every tenant.
+	TenantID string
+}
+
+type Resource struct {
+	// Name is the full resource name, e.g. //storage.googleapis.com/my-bucket, as in the bindings of its IAM policy.
+	Name        string
+	AssetType   string
+	Project     string
+	Location    string
+	Labels      map[string]string
+	DisplayName string
+	State       string
+	// CreateTime is zero when the API doesn't report it.
+	CreateTime  time.Time
+	HierarchyID string
+	TenantID    string
+	// Ancestors are the projects, folders and organization the resource belongs to, itself included if it is one.
+	Ancestors []string
+	// Tags are the tag values attached to the resource itself, not those it inherits.
+	Tags []ResourceTag
+}
+
+// ResourceTag is a tag value attached to a resource. Key and Value are namespaced names, e.g. 123456789/env and
+// 123456789/env/prod, and KeyID is empty when the tag keys are not collected.
+type ResourceTag struct {
+	Key     string
+	KeyID   string
+	Value   string
+	ValueID string
+}
+
+// TagKey is a Resource Manager tag key, e.g. tagKeys/123 named 123456789/env. Parent is the organization or project
+// owning it.
+type TagKey struct {
+	ID             string
+	Parent         string
+	ShortName      string
+	NamespacedName string
+	Description    string
+	TenantID       string
+}
+
+// TagValue is a value of a tag key, e.g. tagValues/456 named 123456789/env/prod.
+type TagValue struct {
+	ID             string
+	KeyID          string
+	ShortName      string
+	NamespacedName string
+	Description    string
+	TenantID       string
+}
+
+// Permission is an IAM permission, e.g. storage.buckets.get, as described by the IAM API. Service is the prefix of its
+// name, e.g. storage, Stage its launch stage, ALPHA, BETA, GA or DEPRECATED, and CustomRolesSupportLevel SUPPORTED,
+// TESTING or NOT_SUPPORTED.
+type Permission struct {
+	Name                    string
+	Title                   string
+	Description             string
+	Service                 string
+	Stage                   string
+	CustomRolesSupportLevel string
+	// APIDisabled is set when the API of the permission is disabled on every resource it was queried on.
+	APIDisabled bool
+}
+
+// ResourceAncestor records that a resource belongs to a project, folder or organization, directly or not.
+type ResourceAncestor struct {
+	ResourceID string
+	AncestorID string
+	TenantID   string
 }
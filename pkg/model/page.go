@@ -0,0 +1,7 @@
+package model
+
+// Page is one page of records returned by a paginated API, along with the token of the next page, empty on the last page.
+type Page[T any] struct {
+	Items         []T
+	NextPageToken string
+}
@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"google.golang.org/api/iterator"
+	"io"
+)
+
+type gcsSink struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+func newGCSSink(ctx context.Context, bucketName string) (*gcsSink, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsSink{client: client, bucket: client.Bucket(bucketName)}, nil
+}
+
+func (s *gcsSink) Stat(ctx context.Context, key string) (ObjectAttrs, error) {
+	attrs, err := s.bucket.Object(key).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ObjectAttrs{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	return ObjectAttrs{
+		Size:        attrs.Size,
+		CRC32C:      attrs.CRC32C,
+		HasCRC32C:   true,
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+	}, nil
+}
+
+func (s *gcsSink) Put(ctx context.Context, key string, r io.Reader, attrs ObjectAttrs) error {
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = attrs.ContentType
+	w.Metadata = attrs.Metadata
+	w.CRC32C = attrs.CRC32C
+	w.SendCRC32C = attrs.HasCRC32C
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *gcsSink) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotExist
+	}
+	return r, err
+}
+
+func (s *gcsSink) List(ctx context.Context, prefix string) ([]string, error) {
+	query := &storage.Query{}
+	if prefix != "" {
+		query.Prefix = prefix + "/"
+	}
+	var keys []string
+	it := s.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, attrs.Name)
+	}
+	return keys, nil
+}
+
+func (s *gcsSink) Close() error {
+	return s.client.Close()
+}
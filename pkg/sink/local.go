@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// localSink writes objects to the local filesystem, keys being file paths.
+type localSink struct{}
+
+func newLocalSink() *localSink {
+	return &localSink{}
+}
+
+func (s *localSink) Stat(ctx context.Context, key string) (ObjectAttrs, error) {
+	file, err := os.Open(filepath.FromSlash(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectAttrs{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	defer file.Close()
+	return statReader(file)
+}
+
+func (s *localSink) Put(ctx context.Context, key string, r io.Reader, attrs ObjectAttrs) error {
+	dst := filepath.FromSlash(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see a partial object.
+	tmp := dst + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (s *localSink) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.FromSlash(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+func (s *localSink) List(ctx context.Context, prefix string) ([]string, error) {
+	root := filepath.FromSlash(prefix)
+	if root == "" {
+		root = "."
+	}
+	var keys []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			keys = append(keys, filepath.ToSlash(p))
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (s *localSink) Close() error {
+	return nil
+}
+
+// statReader computes the attributes of an object by reading it, for destinations that do not store checksums.
+func statReader(r io.Reader) (ObjectAttrs, error) {
+	h := crc32.New(crc32cTable)
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	return ObjectAttrs{Size: size, CRC32C: h.Sum32(), HasCRC32C: true}, nil
+}
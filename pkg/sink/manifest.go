@@ -0,0 +1,159 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest object written next to the uploaded files.
+const ManifestName = "manifest.json"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type Manifest struct {
+	SnapshotID  string           `json:"snapshotId"`
+	ToolVersion string           `json:"toolVersion"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	Objects     []ManifestObject `json:"objects"`
+}
+
+// ManifestObject describes one uploaded object. Name is relative to the manifest prefix and CRC32C is
+// base64-encoded in big-endian byte order, the same representation GCS uses.
+type ManifestObject struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	CRC32C string `json:"crc32c"`
+}
+
+func newManifest(opts UploadOptions, objects []ManifestObject) Manifest {
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return Manifest{
+		SnapshotID:  opts.SnapshotID,
+		ToolVersion: opts.ToolVersion,
+		CreatedAt:   time.Now().UTC(),
+		Objects:     objects,
+	}
+}
+
+func writeManifest(ctx context.Context, s Sink, prefix string, manifest Manifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	object, crc, err := checksum(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, path.Join(prefix, ManifestName), bytes.NewReader(content), ObjectAttrs{
+		Size:        object.Size,
+		CRC32C:      crc,
+		HasCRC32C:   true,
+		ContentType: "application/json",
+	})
+}
+
+// checksum reads r to the end and returns its size, SHA-256 and CRC32C.
+func checksum(r io.Reader) (ManifestObject, uint32, error) {
+	sha := sha256.New()
+	crc := crc32.New(crc32cTable)
+	size, err := io.Copy(io.MultiWriter(sha, crc), r)
+	if err != nil {
+		return ManifestObject{}, 0, err
+	}
+	return ManifestObject{
+		Size:   size,
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+		CRC32C: encodeCRC32C(crc.Sum32()),
+	}, crc.Sum32(), nil
+}
+
+func encodeCRC32C(crc uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, crc)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// VerifyManifest downloads the manifest stored under prefix and checks that every object it lists exists with
+// the recorded size, CRC32C and SHA-256. It returns the manifest along with one problem per failed check.
+func VerifyManifest(ctx context.Context, s Sink, prefix string) (Manifest, []string, error) {
+	var manifest Manifest
+	r, err := s.Get(ctx, path.Join(prefix, ManifestName))
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("reading manifest: %v", err)
+	}
+	err = json.NewDecoder(r).Decode(&manifest)
+	r.Close()
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("decoding manifest: %v", err)
+	}
+
+	var problems []string
+	listed := map[string]bool{ManifestName: true}
+	for _, expected := range manifest.Objects {
+		listed[expected.Name] = true
+		problem, err := verifyObject(ctx, s, path.Join(prefix, expected.Name), expected)
+		if err != nil {
+			return Manifest{}, nil, err
+		}
+		if problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", expected.Name, problem))
+		}
+	}
+
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	for _, key := range keys {
+		if name := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/"); !listed[name] {
+			log.Printf("Object %s is not listed in the manifest", key)
+		}
+	}
+
+	return manifest, problems, nil
+}
+
+func verifyObject(ctx context.Context, s Sink, key string, expected ManifestObject) (string, error) {
+	attrs, err := s.Stat(ctx, key)
+	if errors.Is(err, ErrNotExist) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if attrs.Size != expected.Size {
+		return fmt.Sprintf("size is %d, expected %d", attrs.Size, expected.Size), nil
+	}
+
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	actual, _, err := checksum(r)
+	if err != nil {
+		return "", err
+	}
+	if actual.CRC32C != expected.CRC32C {
+		return fmt.Sprintf("CRC32C is %s, expected %s", actual.CRC32C, expected.CRC32C), nil
+	}
+	if actual.SHA256 != expected.SHA256 {
+		return fmt.Sprintf("SHA-256 is %s, expected %s", actual.SHA256, expected.SHA256), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,115 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// crc32cMetadataKey is the user metadata entry holding the CRC32C of objects written to S3,
+// which has no CRC32C attribute of its own for single-part uploads.
+const crc32cMetadataKey = "Crc32c"
+
+type s3Sink struct {
+	client *minio.Client
+	bucket string
+}
+
+// newS3Sink connects to the bucket named in u. The endpoint defaults to AWS and can be overridden with the
+// endpoint, region and insecure query parameters, e.g. s3://bucket/prefix?endpoint=localhost:9000&insecure=true.
+// Credentials are read from the AWS_* or MINIO_* environment variables, the AWS credentials file or the instance role.
+func newS3Sink(u *url.URL) (*s3Sink, error) {
+	query := u.Query()
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	insecure, _ := strconv.ParseBool(query.Get("insecure"))
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Secure: !insecure,
+		Region: query.Get("region"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("minio.New: %v", err)
+	}
+	return &s3Sink{client: client, bucket: u.Host}, nil
+}
+
+func (s *s3Sink) Stat(ctx context.Context, key string) (ObjectAttrs, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ObjectAttrs{}, ErrNotExist
+		}
+		return ObjectAttrs{}, err
+	}
+	attrs := ObjectAttrs{
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		Metadata:    map[string]string{},
+	}
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, crc32cMetadataKey) {
+			if crc, err := strconv.ParseUint(v, 10, 32); err == nil {
+				attrs.CRC32C = uint32(crc)
+				attrs.HasCRC32C = true
+			}
+			continue
+		}
+		attrs.Metadata[k] = v
+	}
+	return attrs, nil
+}
+
+func (s *s3Sink) Put(ctx context.Context, key string, r io.Reader, attrs ObjectAttrs) error {
+	metadata := map[string]string{}
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	if attrs.HasCRC32C {
+		metadata[crc32cMetadataKey] = strconv.FormatUint(uint64(attrs.CRC32C), 10)
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, attrs.Size, minio.PutObjectOptions{
+		ContentType:  attrs.ContentType,
+		UserMetadata: metadata,
+	})
+	return err
+}
+
+func (s *s3Sink) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *s3Sink) List(ctx context.Context, prefix string) ([]string, error) {
+	opts := minio.ListObjectsOptions{Recursive: true}
+	if prefix != "" {
+		opts.Prefix = prefix + "/"
+	}
+	var keys []string
+	for object := range s.client.ListObjects(ctx, s.bucket, opts) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (s *s3Sink) Close() error {
+	return nil
+}
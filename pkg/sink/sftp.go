@@ -0,0 +1,147 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+type sftpSink struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+// newSFTPSink connects to sftp://user@host[:port]/path. It authenticates with the password from the URL, the private
+// key given by the key query parameter, or the SSH agent, and checks the host key against ~/.ssh/known_hosts unless
+// the knownHosts query parameter points to another file.
+func newSFTPSink(u *url.URL) (*sftpSink, error) {
+	query := u.Query()
+	var auth []ssh.AuthMethod
+	if password, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	if keyFile := query.Get("key"); keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", keyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to SSH agent: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	knownHostsFile := query.Get("knownHosts")
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading known hosts: %v", err)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	sshClient, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssh.Dial: %v", err)
+	}
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("sftp.NewClient: %v", err)
+	}
+	return &sftpSink{ssh: sshClient, client: client}, nil
+}
+
+func (s *sftpSink) Stat(ctx context.Context, key string) (ObjectAttrs, error) {
+	file, err := s.client.Open(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectAttrs{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	defer file.Close()
+	return statReader(file)
+}
+
+func (s *sftpSink) Put(ctx context.Context, key string, r io.Reader, attrs ObjectAttrs) error {
+	if err := s.client.MkdirAll(path.Dir(key)); err != nil {
+		return err
+	}
+	tmp := key + ".tmp"
+	file, err := s.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.ReadFrom(r); err != nil {
+		file.Close()
+		s.client.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		s.client.Remove(tmp)
+		return err
+	}
+	return s.client.PosixRename(tmp, key)
+}
+
+func (s *sftpSink) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := s.client.Open(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+func (s *sftpSink) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	walker := s.client.Walk(prefix)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if walker.Stat().Mode().IsRegular() {
+			keys = append(keys, walker.Path())
+		}
+	}
+	return keys, nil
+}
+
+func (s *sftpSink) Close() error {
+	s.client.Close()
+	return s.ssh.Close()
+}
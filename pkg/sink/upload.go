@@ -0,0 +1,189 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// UploadOptions controls how files are written to a sink.
+type UploadOptions struct {
+	OrgID       string
+	Date        string
+	SnapshotID  string
+	Parallelism int
+	Metadata    map[string]string
+	ToolVersion string
+}
+
+type uploadTask struct {
+	srcPath string
+	dstPath string
+}
+
+// Upload uploads the specified path to the sink. If the path is a directory, it uploads all files in the directory
+// tree, preserving their path relative to srcPath. If the path is a file, it uploads the single file.
+// Objects are named after prefix and objects whose CRC32C already matches the local file are skipped.
+// Once every file is uploaded, a manifest listing the objects and their checksums is written under the same prefix.
+func Upload(ctx context.Context, s Sink, prefix, srcPath string, opts UploadOptions) error {
+	tasks, err := listUploadTasks(srcPath, prefix)
+	if err != nil {
+		return err
+	}
+
+	parallelism := opts.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	taskChan := make(chan uploadTask)
+	errChan := make(chan error, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var objects []ManifestObject
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				object, err := uploadFile(ctx, s, task.srcPath, task.dstPath, opts.Metadata)
+				if err != nil {
+					errChan <- fmt.Errorf("uploading %s: %v", task.srcPath, err)
+					cancel()
+					return
+				}
+				object.Name = strings.TrimPrefix(strings.TrimPrefix(task.dstPath, prefix), "/")
+				mu.Lock()
+				objects = append(objects, object)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		defer close(taskChan)
+		for _, task := range tasks {
+			select {
+			case taskChan <- task:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errChan)
+	if err := <-errChan; err != nil {
+		return err
+	}
+
+	manifest := newManifest(opts, objects)
+	if err := writeManifest(ctx, s, prefix, manifest); err != nil {
+		return fmt.Errorf("writing manifest: %v", err)
+	}
+	log.Printf("Wrote manifest of %d objects to %s", len(objects), path.Join(prefix, ManifestName))
+	return nil
+}
+
+// listUploadTasks walks srcPath and maps every regular file to its object name under prefix.
+func listUploadTasks(srcPath, prefix string) ([]uploadTask, error) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []uploadTask{{srcPath: srcPath, dstPath: path.Join(prefix, filepath.Base(srcPath))}}, nil
+	}
+
+	var tasks []uploadTask
+	err = filepath.WalkDir(srcPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(srcPath, p)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestName {
+			return fmt.Errorf("%s would be overwritten by the upload manifest", p)
+		}
+		tasks = append(tasks, uploadTask{srcPath: p, dstPath: path.Join(prefix, filepath.ToSlash(rel))})
+		return nil
+	})
+	return tasks, err
+}
+
+func uploadFile(ctx context.Context, s Sink, srcPath, dstPath string, metadata map[string]string) (ManifestObject, error) {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return ManifestObject{}, err
+	}
+	defer file.Close()
+
+	object, crc, err := checksumFile(file)
+	if err != nil {
+		return ManifestObject{}, err
+	}
+
+	attrs, err := s.Stat(ctx, dstPath)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return ManifestObject{}, err
+	}
+	if err == nil && attrs.HasCRC32C && attrs.CRC32C == crc {
+		log.Printf("Skipped %s, %s is up to date", srcPath, dstPath)
+		return object, nil
+	}
+
+	err = s.Put(ctx, dstPath, file, ObjectAttrs{
+		Size:        object.Size,
+		CRC32C:      crc,
+		HasCRC32C:   true,
+		ContentType: contentType(srcPath),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return ManifestObject{}, err
+	}
+	log.Printf("Uploaded %s to %s", srcPath, dstPath)
+	return object, nil
+}
+
+// checksumFile computes the size, SHA-256 and CRC32C of file and rewinds it.
+func checksumFile(file *os.File) (ManifestObject, uint32, error) {
+	object, crc, err := checksum(file)
+	if err != nil {
+		return ManifestObject{}, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ManifestObject{}, 0, err
+	}
+	return object, crc, nil
+}
+
+func contentType(srcPath string) string {
+	switch strings.ToLower(filepath.Ext(srcPath)) {
+	case ".csv":
+		return "text/csv"
+	case ".db":
+		return "application/vnd.sqlite3"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(srcPath)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,133 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files of a local directory, keyed by slash-separated relative path.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// uploadLocal uploads a directory holding files to a local destination and returns the sink and key prefix.
+func uploadLocal(t *testing.T, files map[string]string) (Sink, string) {
+	t.Helper()
+	ctx := context.Background()
+	src := t.TempDir()
+	writeFiles(t, src, files)
+	s, prefix, err := Open(ctx, "file://"+filepath.ToSlash(t.TempDir())+"/snapshots/1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := Upload(ctx, s, prefix, src, UploadOptions{SnapshotID: "1", ToolVersion: "test", Parallelism: 2}); err != nil {
+		t.Fatal(err)
+	}
+	return s, prefix
+}
+
+func TestUploadLocal(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"role.csv":             "id,title\nroles/viewer,Viewer\n",
+		"tables/principal.csv": "id,name\n",
+		"database.db":          "SQLite format 3",
+	}
+	s, prefix := uploadLocal(t, files)
+
+	for name, content := range files {
+		r, err := s.Get(ctx, path.Join(prefix, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		uploaded, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(uploaded) != content {
+			t.Errorf("%s holds %q, want %q", name, uploaded, content)
+		}
+	}
+
+	manifest, problems, err := VerifyManifest(ctx, s, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("VerifyManifest reported %v on an untouched upload", problems)
+	}
+	if manifest.SnapshotID != "1" || manifest.ToolVersion != "test" {
+		t.Errorf("manifest snapshot %q and tool version %q, want 1 and test", manifest.SnapshotID, manifest.ToolVersion)
+	}
+	var names []string
+	for _, object := range manifest.Objects {
+		names = append(names, object.Name)
+		if object.Size != int64(len(files[object.Name])) {
+			t.Errorf("manifest size of %s is %d, want %d", object.Name, object.Size, len(files[object.Name]))
+		}
+	}
+	if got, want := strings.Join(names, " "), "database.db role.csv tables/principal.csv"; got != want {
+		t.Errorf("manifest lists %s, want %s", got, want)
+	}
+}
+
+func TestVerifyManifestTampered(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, key string)
+		want   string
+	}{
+		{"same size", func(t *testing.T, key string) {
+			writeFiles(t, "", map[string]string{key: "id,title\nroles/editor,Editor\n"})
+		}, "role.csv: CRC32C is"},
+		{"other size", func(t *testing.T, key string) { writeFiles(t, "", map[string]string{key: "id,title\n"}) }, "role.csv: size is 9, expected 29"},
+		{"deleted", func(t *testing.T, key string) {
+			if err := os.Remove(filepath.FromSlash(key)); err != nil {
+				t.Fatal(err)
+			}
+		}, "role.csv: missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, prefix := uploadLocal(t, map[string]string{"role.csv": "id,title\nroles/viewer,Viewer\n", "other.csv": "x\n"})
+			tt.tamper(t, path.Join(prefix, "role.csv"))
+
+			_, problems, err := VerifyManifest(ctx, s, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], tt.want) {
+				t.Errorf("VerifyManifest reported %q, want a single problem starting with %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadRejectsManifestFile(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{ManifestName: "{}"})
+	s, prefix, err := Open(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Upload(context.Background(), s, prefix, src, UploadOptions{}); err == nil {
+		t.Errorf("Upload of a directory holding %s succeeded", ManifestName)
+	}
+}